@@ -0,0 +1,227 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"debridGo/types"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	rarPart   = regexp.MustCompile(`(?i)\.part(\d+)\.rar$`)    // Movie.part01.rar, Movie.part02.rar...
+	splitPart = regexp.MustCompile(`(?i)\.(7z|zip)\.(\d{3})$`) // Movie.7z.001, Movie.7z.002...
+)
+
+// Extract the archives of dir and its subdirectories next to them, then the archives they contained, until there are no
+// new ones. Every archive is tested before it is extracted, and encrypted archives are tried with the passwords of the
+// config. An archive that can't be extracted is skipped, the error has the ones that contain media. Returns the
+// extracted archives.
+func ExtractAll(ctx context.Context, conf types.TomlConfig, dir string) ([]string, error) {
+	var (
+		extracted []string
+		failed    []string // Errors of the archives with media.
+		tried     = map[string]bool{}
+	)
+	for {
+		archives, err := find(dir, tried)
+		if err != nil {
+			return extracted, err
+		}
+		if len(archives) == 0 {
+			break
+		}
+
+		for _, a := range archives {
+			tried[a] = true
+			err = Extract(ctx, conf, a)
+			if ctx.Err() != nil {
+				return extracted, ctx.Err()
+			}
+			if err != nil && hasMedia(ctx, conf, a) {
+				failed = append(failed, fmt.Sprintf("extracting %v: %v", filepath.Base(a), err))
+				continue
+			}
+			if err != nil {
+				log.Printf("Skipping %v, it has no media: %v", filepath.Base(a), err)
+				continue
+			}
+			extracted = append(extracted, a)
+		}
+	}
+
+	if len(failed) > 0 {
+		return extracted, errors.New(strings.Join(failed, "; "))
+	}
+	return extracted, nil
+}
+
+// Archives of dir and its subdirectories not tried yet.
+func find(dir string, tried map[string]bool) ([]string, error) {
+	var archives []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && archiveType(path) != "" && !tried[path] {
+			archives = append(archives, path)
+		}
+		return nil
+	})
+	return archives, err
+}
+
+// Whether an archive has video files or other archives, which can have video too. Archives that can't be listed, e.g.
+// with encrypted names and none of the passwords of the config, are taken as having media.
+func hasMedia(ctx context.Context, conf types.TomlConfig, path string) bool {
+	tool, _, _, list := commands(conf, archiveType(path), path)
+
+	passwords := append([]string{""}, conf.Archives.Passwords...)
+	for _, password := range passwords {
+		output, err := run(ctx, tool, list(password))
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(output, "\n") {
+			// 7z lists the archive itself first.
+			name := strings.TrimSpace(strings.TrimPrefix(line, "Path = "))
+			if name == "" || name == path {
+				continue
+			}
+			ext := strings.ToLower(filepath.Ext(name))
+			if ext == ".mp4" || ext == ".mkv" || archiveType(name) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Test an archive and extract it next to it. path is the first volume of a multipart archive, the .rar of a .r00 set.
+func Extract(ctx context.Context, conf types.TomlConfig, path string) error {
+	tool, test, extract, _ := commands(conf, archiveType(path), path)
+	if tool == "" {
+		return fmt.Errorf("not an archive: %v", path)
+	}
+
+	// No password first, it also works for archives that aren't encrypted.
+	passwords := append([]string{""}, conf.Archives.Passwords...)
+
+	var err error
+	for _, password := range passwords {
+		_, err = run(ctx, tool, test(password))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			log.Printf("Extracting %v.", filepath.Base(path))
+			_, err = run(ctx, tool, extract(password))
+			return err
+		}
+	}
+
+	return fmt.Errorf("integrity check failed: %w", err)
+}
+
+// Type of the archive of a file: rar, zip or 7z. Empty if it isn't an archive or is a later volume of one.
+func archiveType(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+
+	if m := rarPart.FindStringSubmatch(name); m != nil {
+		if n, _ := strconv.Atoi(m[1]); n != 1 {
+			return ""
+		}
+		return "rar"
+	}
+	if m := splitPart.FindStringSubmatch(name); m != nil {
+		if n, _ := strconv.Atoi(m[2]); n != 1 {
+			return ""
+		}
+		return m[1]
+	}
+
+	switch filepath.Ext(name) {
+	case ".rar":
+		return "rar"
+	case ".zip":
+		return "zip"
+	case ".7z":
+		return "7z"
+	}
+	return ""
+}
+
+// Binary of an archive type and the arguments to test, to extract and to list the archive with a password. The list has
+// one file per line or, with 7z, one "Path = " line per file.
+func commands(conf types.TomlConfig, archiveType, path string) (tool string, test, extract, list func(password string) []string) {
+	dir := filepath.Dir(path)
+
+	switch archiveType {
+	case "rar":
+		tool = conf.Archives.Unrar
+		if tool == "" {
+			tool = "unrar"
+		}
+		// -p- makes unrar fail instead of asking for a password.
+		password := func(p string) string {
+			if p == "" {
+				return "-p-"
+			}
+			return "-p" + p
+		}
+		test = func(p string) []string {
+			return []string{"t", "-idq", password(p), path}
+		}
+		extract = func(p string) []string {
+			return []string{"x", "-o+", "-idq", password(p), path, dir + "/"}
+		}
+		list = func(p string) []string {
+			return []string{"lb", password(p), path}
+		}
+	case "zip", "7z":
+		tool = conf.Archives.SevenZip
+		if tool == "" {
+			tool = "7z"
+		}
+		test = func(p string) []string {
+			return []string{"t", "-y", "-p" + p, path}
+		}
+		extract = func(p string) []string {
+			return []string{"x", "-y", "-p" + p, "-o" + dir, path}
+		}
+		list = func(p string) []string {
+			return []string{"l", "-slt", "-p" + p, path}
+		}
+	}
+
+	return tool, test, extract, list
+}
+
+// Run an archive tool and get its output. The error has the last line of the output, the arguments aren't logged as they
+// have the password.
+func run(ctx context.Context, tool string, args []string) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err != nil && output.Len() > 0 {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		return "", fmt.Errorf("%v failed: %w: %v", filepath.Base(tool), err, strings.TrimSpace(lines[len(lines)-1]))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v failed: %w", filepath.Base(tool), err)
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,202 @@
+package bazarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pollInterval = 10 * time.Second // Interval between checks of the subtitles bazarr has for a movie/episode.
+	syncTimeout  = 5 * time.Minute  // Max time to wait for bazarr to pick up a new file from sonarr/radarr.
+)
+
+// Max time the subtitle searches of a job can take altogether, as a deadline of the context passed to SearchMovie and
+// SearchEpisode.
+const SearchTimeout = 15 * time.Minute
+
+// Subtitle language found or missing for a movie/episode in bazarr.
+type Subtitle struct {
+	Name   string `json:"name"`
+	Code2  string `json:"code2"`
+	Code3  string `json:"code3"`
+	Path   string `json:"path"`
+	Forced bool   `json:"forced"`
+	Hi     bool   `json:"hi"`
+}
+
+// Language tag of the subtitle, e.g. "en", "en:forced" or "es:hi".
+func (s Subtitle) String() string {
+	switch {
+	case s.Forced:
+		return s.Code2 + ":forced"
+	case s.Hi:
+		return s.Code2 + ":hi"
+	}
+	return s.Code2
+}
+
+type item struct {
+	Path             string     `json:"path"`
+	Subtitles        []Subtitle `json:"subtitles"`
+	MissingSubtitles []Subtitle `json:"missing_subtitles"`
+}
+
+// Search subtitles for a movie already imported by radarr and wait until bazarr finishes. filePath is the path of the
+// movie file in radarr. Returns the subtitles bazarr has for the movie.
+func SearchMovie(ctx context.Context, apiURL, apiKey string, movieRadarrId, movieFileId int, filePath string) ([]Subtitle, error) {
+	query := "/movies?radarrid[]=" + strconv.Itoa(movieRadarrId)
+	webhook := url.Values{"radarr_moviefile_id": {strconv.Itoa(movieFileId)}}
+
+	return search(ctx, apiURL, apiKey, query, filePath, "/webhooks/radarr", webhook)
+}
+
+// Search subtitles for an episode already imported by sonarr and wait until bazarr finishes. filePath is the path of the
+// episode file in sonarr. Returns the subtitles bazarr has for the episode.
+func SearchEpisode(ctx context.Context, apiURL, apiKey string, episodeSonarrId, episodeFileId int, filePath string) ([]Subtitle, error) {
+	query := "/episodes?episodeid[]=" + strconv.Itoa(episodeSonarrId)
+	webhook := url.Values{"sonarr_episodefile_id": {strconv.Itoa(episodeFileId)}}
+
+	return search(ctx, apiURL, apiKey, query, filePath, "/webhooks/sonarr", webhook)
+}
+
+// Languages of the subtitles joined by commas.
+func Languages(subtitles []Subtitle) string {
+	var languages []string
+	for _, s := range subtitles {
+		languages = append(languages, s.String())
+	}
+	if len(languages) == 0 {
+		return "none"
+	}
+	return strings.Join(languages, ", ")
+}
+
+func search(ctx context.Context, apiURL, apiKey, query, filePath, webhookPath string, webhookData url.Values) ([]Subtitle, error) {
+	base := baseURL(apiURL)
+
+	// Bazarr only searches files it already knows about, so wait until it syncs the new file from sonarr/radarr. After an
+	// upgrade it knows the old file first.
+	syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	err := waitForFile(syncCtx, base+query, apiKey, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("bazarr does not know %v of %v yet: %w", fileName(filePath), query, err)
+	}
+
+	// The webhook answers once bazarr has searched and downloaded the missing subtitles, so the result is read once.
+	// Languages without subtitles stay missing, waiting for them would only wait for the timeout.
+	log.Printf("Requesting subtitles search to bazarr: %v", webhookData.Encode())
+	err = post(ctx, base+webhookPath, apiKey, webhookData)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := get(ctx, base+query, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("bazarr does not know " + query + " anymore")
+	}
+	i := items[0]
+
+	if len(i.MissingSubtitles) > 0 {
+		log.Printf("Bazarr could not find subtitles for: %v", Languages(i.MissingSubtitles))
+	}
+
+	return i.Subtitles, nil
+}
+
+// Poll bazarr until the movie/episode has the file of filePath. Bazarr may map the sonarr/radarr paths to its own, so
+// only the file names are compared. An empty filePath matches any file.
+func waitForFile(ctx context.Context, endpoint, apiKey, filePath string) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		items, err := get(ctx, endpoint, apiKey)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if len(items) > 0 && (filePath == "" || fileName(items[0].Path) == fileName(filePath)) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func get(ctx context.Context, endpoint, apiKey string) ([]item, error) {
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bazarr GET %v returned: %v", req.URL.Path, resp.Status)
+	}
+
+	type ResponseBody struct {
+		Data []item `json:"data"`
+	}
+	var body ResponseBody
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.Data, nil
+}
+
+func post(ctx context.Context, endpoint, apiKey string, data url.Values) error {
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bazarr POST %v returned: %v", req.URL.Path, resp.Status)
+	}
+
+	return nil
+}
+
+// Name of a file from its path in sonarr/radarr/bazarr, which can run on Windows.
+func fileName(filePath string) string {
+	return path.Base(strings.ReplaceAll(filePath, `\`, "/"))
+}
+
+// The bazarr ApiURL used to point to the webhooks endpoint (e.g. http://bazarr:6767/api/webhooks). Accept both that and the api root.
+func baseURL(apiURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(apiURL, "/"), "/webhooks")
+}
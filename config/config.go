@@ -35,31 +35,3 @@ func Values() (types.TomlConfig, error) {
 
 	return conf, nil
 }
-
-func SetFfmpeg(value bool) error {
-	var conf types.TomlConfig
-
-	conf.Ffmpeg.Running = value
-
-	// Get executable path.
-	ex, err := ExecutableDir()
-	if err != nil {
-		return err
-	}
-
-	f, err := os.Open(ex + "/configDebridGo.toml")
-	if err != nil {
-		// failed to create/open the file
-		return err
-	}
-	if err := toml.NewEncoder(f).Encode(conf); err != nil {
-		// failed to encode
-		return err
-	}
-	if err := f.Close(); err != nil {
-		// failed to close the file
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,89 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"fmt"
+	"strings"
+)
+
+// Audio stream of a file, as Plan sees it.
+type audioStream struct {
+	Codec      string
+	Channels   int
+	Layout     string
+	Language   string // Two letter code, empty or "und" when unknown.
+	Title      string
+	Default    bool
+	Commentary bool
+}
+
+// Choose the audio streams kept in the output and, among them, the ones in the most preferred language of the profile.
+// Streams of unknown language are kept, as they can't be told apart from the wanted ones. At least one stream is
+// always kept. Commentary streams are only preferred when nothing else is kept, so they never become the default one.
+func selectAudio(plan *ConversionPlan, audio []audioStream, profile types.ConversionProfile) (kept, preferred []int) {
+	var languages []string
+	for _, l := range profile.AudioLanguages {
+		if l == "original" {
+			l = audio[0].Language
+		}
+		languages = append(languages, languageCode(l))
+	}
+
+	for i, a := range audio {
+		switch {
+		case profile.DropCommentary && a.Commentary:
+			plan.reason("audio a:%v (%v %q) is dropped: commentary", i, a.Language, a.Title)
+		case len(languages) > 0 && knownLanguage(a.Language) && !containsString(languages, a.Language):
+			plan.reason("audio a:%v (%v %q) is dropped: language not wanted", i, a.Language, a.Title)
+		default:
+			kept = append(kept, i)
+		}
+	}
+
+	if len(kept) == 0 {
+		plan.reason("no audio stream is wanted, all of them are kept")
+		for i := range audio {
+			kept = append(kept, i)
+		}
+	}
+
+	var candidates []int
+	for _, i := range kept {
+		if !audio[i].Commentary {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = kept
+	}
+
+	for _, l := range languages {
+		for _, i := range candidates {
+			if audio[i].Language == l {
+				preferred = append(preferred, i)
+			}
+		}
+		if len(preferred) > 0 {
+			plan.reason("preferred audio language: %v", l)
+			return kept, preferred
+		}
+	}
+
+	return kept, candidates
+}
+
+func knownLanguage(code string) bool {
+	return code != "" && code != "und"
+}
+
+// Title of a created audio stream, e.g. "AAC Stereo".
+func audioTitle(codec string, channels int) string {
+	layout := fmt.Sprintf("%v.1", channels-1)
+	switch channels {
+	case 1:
+		layout = "Mono"
+	case 2:
+		layout = "Stereo"
+	}
+	return strings.ToUpper(codec) + " " + layout
+}
@@ -0,0 +1,156 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Kinds of media files of a download.
+const (
+	MediaMain    = "main"    // The movie, or a part of it.
+	MediaEpisode = "episode" // An episode of the season.
+	MediaSample  = "sample"  // Short cut of the release.
+	MediaExtra   = "extra"   // Trailer, featurette, deleted scene...
+)
+
+// What is done with the extras of a download.
+const (
+	ExtrasConvert = "convert" // Converted like the main files and uploaded as extras.
+	ExtrasUpload  = "upload"  // Uploaded as extras as they are.
+	ExtrasDiscard = "discard" // Not uploaded.
+)
+
+// How the media in subdirectories of a download is uploaded.
+const (
+	LayoutFlatten  = "flatten"  // Moved into the download directory.
+	LayoutPreserve = "preserve" // Left in its subdirectory.
+)
+
+// Files shorter than this, and much smaller than the largest one, are samples.
+const (
+	sampleDuration  = 5 * 60 // Seconds.
+	sampleSizeRatio = 0.1
+)
+
+// Files at least this big compared to the largest one are episodes of a season pack without episode tags, or parts
+// (CD1/CD2) or copies of a movie.
+const mainSizeRatio = 0.5
+
+// Files named as a sample or extra are only ones when they are smaller than this compared to the largest file, so a
+// title like "Free.Samples.2012" or "Extras.S01E01" is never taken for one.
+const namedSizeRatio = 0.5
+
+var (
+	sampleName = regexp.MustCompile(`(?i)(^|[\W_])samples?([\W_]|$)`)
+	extraName  = regexp.MustCompile(`(?i)(^|[\W_])(trailers?|teasers?|featurettes?|extras?|bonus|interviews?|deleted[\W_]scenes?|behind[\W_]the[\W_]scenes|making[\W_]of|bloopers?)([\W_]|$)`)
+)
+
+// Media file of a download and what it is.
+type MediaFile struct {
+	Path     string  `json:"path"`
+	Kind     string  `json:"kind"`
+	Duration float64 `json:"duration"` // Seconds. 0 if ffprobe can't read the file.
+	Size     int64   `json:"size"`
+	Reason   string  `json:"reason"`
+}
+
+// Tell the main feature or episodes of a download from its samples and extras, from the file and directory names
+// relative to saveDir, their durations and their sizes compared to the largest file. In a radarr download the largest
+// file and the ones about as big are the movie, in a sonarr one episodes: the largest file is never a sample or an
+// extra.
+func Classify(saveDir string, files []string, category string) ([]MediaFile, error) {
+	media := make([]MediaFile, len(files))
+	var largest int64
+	for i, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		media[i] = MediaFile{Path: f, Size: info.Size(), Duration: probeDuration(f)}
+		if info.Size() > largest {
+			largest = info.Size()
+		}
+	}
+
+	for i := range media {
+		m := &media[i]
+		name, err := filepath.Rel(saveDir, m.Path)
+		if err != nil {
+			name = filepath.Base(m.Path)
+		}
+		ratio := float64(m.Size) / float64(largest)
+
+		// Episode tags are checked before the extra names, which also match show titles like "Trailer Park Boys".
+		switch {
+		case sampleName.MatchString(name) && ratio < namedSizeRatio:
+			m.Kind, m.Reason = MediaSample, fmt.Sprintf("named as a sample, %.0f%% of the largest file", ratio*100)
+		case m.Duration > 0 && m.Duration < sampleDuration && ratio < sampleSizeRatio:
+			m.Kind, m.Reason = MediaSample, fmt.Sprintf("%.0f seconds long, %.0f%% of the largest file", m.Duration, ratio*100)
+		case category == "tv-sonarr" && episodeTag.MatchString(name):
+			m.Kind, m.Reason = MediaEpisode, "episode tag "+episodeTag.FindString(name)
+		case extraName.MatchString(name) && ratio < namedSizeRatio:
+			m.Kind, m.Reason = MediaExtra, fmt.Sprintf("named as an extra, %.0f%% of the largest file", ratio*100)
+		case category == "tv-sonarr" && ratio >= mainSizeRatio:
+			m.Kind, m.Reason = MediaEpisode, fmt.Sprintf("%.0f%% of the largest file", ratio*100)
+		case category == "tv-sonarr":
+			m.Kind, m.Reason = MediaExtra, fmt.Sprintf("no episode tag, %.0f%% of the largest file", ratio*100)
+		case ratio >= mainSizeRatio:
+			m.Kind, m.Reason = MediaMain, fmt.Sprintf("%.0f%% of the largest file", ratio*100)
+		default:
+			m.Kind, m.Reason = MediaExtra, fmt.Sprintf("%.0f%% of the largest file", ratio*100)
+		}
+	}
+
+	return media, nil
+}
+
+// Duration in seconds of a file, 0 if ffprobe can't read it.
+func probeDuration(filePath string) float64 {
+	data, err := ffmpeg.Probe(filePath)
+	if err != nil {
+		return 0
+	}
+
+	var probe VideoFileInfoProbe
+	if json.Unmarshal([]byte(data), &probe) != nil {
+		return 0
+	}
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	return duration
+}
+
+// What is done with the extras of a category: convert, upload or discard. Defaults to discard.
+func ExtrasAction(conf types.TomlConfig, category string) (string, error) {
+	action, ok := conf.Conversion.Extras[category]
+	if !ok || action == "" {
+		return ExtrasDiscard, nil
+	}
+
+	action = strings.ToLower(action)
+	if action != ExtrasConvert && action != ExtrasUpload && action != ExtrasDiscard {
+		return "", fmt.Errorf("unknown extras action for %v: %v", category, action)
+	}
+	return action, nil
+}
+
+// How the media in subdirectories of a category is uploaded: flatten or preserve. Defaults to flatten.
+func Layout(conf types.TomlConfig, category string) (string, error) {
+	layout, ok := conf.Conversion.Layouts[category]
+	if !ok || layout == "" {
+		return LayoutFlatten, nil
+	}
+
+	layout = strings.ToLower(layout)
+	if layout != LayoutFlatten && layout != LayoutPreserve {
+		return "", fmt.Errorf("unknown layout for %v: %v", category, layout)
+	}
+	return layout, nil
+}
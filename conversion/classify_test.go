@@ -0,0 +1,103 @@
+package conversion
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	const mb = 1 << 20
+
+	tests := []struct {
+		name     string
+		category string
+		files    map[string]int64 // Size of each file relative to the download.
+		kinds    map[string]string
+	}{
+		{
+			name:     "movie with a sample and a trailer",
+			category: "radarr",
+			files:    map[string]int64{"Movie.2020.mkv": 4000 * mb, "Sample/movie-sample.mkv": 50 * mb, "Movie.2020.Trailer.mkv": 100 * mb},
+			kinds:    map[string]string{"Movie.2020.mkv": MediaMain, "Sample/movie-sample.mkv": MediaSample, "Movie.2020.Trailer.mkv": MediaExtra},
+		},
+		{
+			name:     "movie titled as a sample",
+			category: "radarr",
+			files:    map[string]int64{"Free.Samples.2012.mkv": 2000 * mb, "Free.Samples.2012.sample.mkv": 40 * mb},
+			kinds:    map[string]string{"Free.Samples.2012.mkv": MediaMain, "Free.Samples.2012.sample.mkv": MediaSample},
+		},
+		{
+			name:     "movie titled as an extra",
+			category: "radarr",
+			files:    map[string]int64{"The.Extra.2005.mkv": 2000 * mb},
+			kinds:    map[string]string{"The.Extra.2005.mkv": MediaMain},
+		},
+		{
+			name:     "movie in two parts",
+			category: "radarr",
+			files:    map[string]int64{"Movie.1994.CD1.mkv": 1400 * mb, "Movie.1994.CD2.mkv": 1300 * mb, "Movie.1994.Featurette.mkv": 200 * mb},
+			kinds:    map[string]string{"Movie.1994.CD1.mkv": MediaMain, "Movie.1994.CD2.mkv": MediaMain, "Movie.1994.Featurette.mkv": MediaExtra},
+		},
+		{
+			name:     "unnamed small file of a movie",
+			category: "radarr",
+			files:    map[string]int64{"Movie.2020.mkv": 4000 * mb, "Movie.2020.Recap.mkv": 300 * mb},
+			kinds:    map[string]string{"Movie.2020.mkv": MediaMain, "Movie.2020.Recap.mkv": MediaExtra},
+		},
+		{
+			name:     "show titled as an extra",
+			category: "tv-sonarr",
+			files:    map[string]int64{"Trailer.Park.Boys.S01E01.mkv": 400 * mb, "Trailer.Park.Boys.S01E02.mkv": 100 * mb, "Featurettes/Behind.the.Scenes.mkv": 80 * mb},
+			kinds:    map[string]string{"Trailer.Park.Boys.S01E01.mkv": MediaEpisode, "Trailer.Park.Boys.S01E02.mkv": MediaEpisode, "Featurettes/Behind.the.Scenes.mkv": MediaExtra},
+		},
+		{
+			name:     "episode titled as an extra",
+			category: "tv-sonarr",
+			files:    map[string]int64{"Extras.S01E01.mkv": 400 * mb, "Extras.S01E01.sample.mkv": 20 * mb},
+			kinds:    map[string]string{"Extras.S01E01.mkv": MediaEpisode, "Extras.S01E01.sample.mkv": MediaSample},
+		},
+		{
+			name:     "season pack without episode tags",
+			category: "tv-sonarr",
+			files:    map[string]int64{"Show/01.mkv": 400 * mb, "Show/02.mkv": 380 * mb, "Show/Bonus.mkv": 390 * mb, "Show/Recap.mkv": 50 * mb},
+			kinds:    map[string]string{"Show/01.mkv": MediaEpisode, "Show/02.mkv": MediaEpisode, "Show/Bonus.mkv": MediaEpisode, "Show/Recap.mkv": MediaExtra},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			for name, size := range tt.files {
+				path := filepath.Join(dir, name)
+				err := os.MkdirAll(filepath.Dir(path), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f, err := os.Create(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = f.Truncate(size)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, path)
+			}
+
+			media, err := Classify(dir, files, tt.category)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range media {
+				name, _ := filepath.Rel(dir, m.Path)
+				if m.Kind != tt.kinds[name] {
+					t.Errorf("%v is %v (%v), want %v", name, m.Kind, m.Reason, tt.kinds[name])
+				}
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package conversion
 
 import (
-	"encoding/json"
+	"context"
+	"debridGo/types"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
@@ -13,271 +15,270 @@ import (
 
 // Decoding Movie data into JSON
 type VideoFileInfoProbe struct {
-	Streams []struct {
-		Index       int    `json:"index"`
-		CodecType   string `json:"codec_type"`
-		CodecName   string `json:"codec_name"`
-		Channels    int    `json:"channels"`
-		Bitrate     string `json:"bit_rate"`
-		Disposition struct {
-			Default int `json:"default"`
-		} `json:"disposition"`
-		Tags struct {
-			Language    string `json:"language"`
-			HandlerName string `json:"handler_name"`
-			Title       string `json:"title"`
-		} `json:"tags"`
-	} `json:"streams"`
+	Streams []ProbeStream `json:"streams"`
+	Format  struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
 }
 
-func Video(filePath string) error {
+// Stream of the ffprobe output.
+type ProbeStream struct {
+	Index         int    `json:"index"`
+	CodecType     string `json:"codec_type"`
+	CodecName     string `json:"codec_name"`
+	Profile       string `json:"profile"`
+	Level         int    `json:"level"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout"`
+	Bitrate       string `json:"bit_rate"`
+	PixFmt        string `json:"pix_fmt"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	ColorTransfer string `json:"color_transfer"`
+	Disposition   struct {
+		Default         int `json:"default"`
+		Forced          int `json:"forced"`
+		Comment         int `json:"comment"`
+		HearingImpaired int `json:"hearing_impaired"`
+		AttachedPic     int `json:"attached_pic"`
+	} `json:"disposition"`
+	Tags struct {
+		Language    string `json:"language"`
+		HandlerName string `json:"handler_name"`
+		Title       string `json:"title"`
+	} `json:"tags"`
+}
+
+// Convert a video file with the profile and get the path of the converted file, which has the extension of the output
+// container, or filePath if the file wasn't converted. The progress of the conversion is reported to progress, if not
+// nil. Cancelling ctx stops ffmpeg and puts the source file back.
+func Video(ctx context.Context, filePath string, profile types.ConversionProfile, progress ProgressFunc) (string, error) {
 
 	// Convert single video file.
 	log.Println("Obtainig file information for video conversion.")
 	data, err := ffmpeg.Probe(filePath)
 	if err != nil {
-		return err
+		return filePath, err
 	}
 
-	err = convert(data, filePath)
+	return convert(ctx, data, filePath, profile, progress)
+}
+
+// Probe a file and get its conversion plan without converting it.
+func PlanFile(filePath string, profile types.ConversionProfile) (ConversionPlan, error) {
+	data, err := ffmpeg.Probe(filePath)
 	if err != nil {
-		return err
+		return ConversionPlan{File: filePath}, err
 	}
 
-	return nil
+	return Plan(data, filePath, profile)
 }
 
-func convert(fileData string, filePath string) error {
-
-	var (
-		totalAudioStreams       int
-		audioStreamIndex        int
-		audioDefaultStreamIndex int
-		process                 string
-	)
-
-	var vFileInfo VideoFileInfoProbe
-	err := json.Unmarshal([]byte(fileData), &vFileInfo)
+func convert(ctx context.Context, fileData string, filePath string, profile types.ConversionProfile, progress ProgressFunc) (string, error) {
+	plan, err := Plan(fileData, filePath, profile)
 	if err != nil {
-		return err
+		return filePath, err
 	}
 
-	log.Println("Converting: ", filePath)
-
-	subStreamIndex := 0
-	for _, s := range vFileInfo.Streams {
-
-		// Check that file codec is not h265. h265 transcoding to h264 is not supported yet :).
-		if s.CodecType == "video" && s.CodecName == "h265" || s.CodecName == "hevc" {
-			log.Printf("Skipping. %v encoding is not supported", s.CodecName)
-			return nil
-		}
+	log.Printf("Converting: %v. Process: %v", filePath, plan.Process)
+	for _, r := range plan.Reasons {
+		log.Println("  ", r)
+	}
+	for _, d := range plan.Devices {
+		log.Printf("   direct play on %v: %v %v", d.Device, d.DirectPlay, strings.Join(d.Issues, ", "))
+	}
 
-		if s.CodecType == "audio" && s.CodecName == "aac" && s.Channels == 2 && s.Disposition.Default == 1 && strings.Contains(filepath.Ext(filePath), "mp4") {
-			log.Println("File meets requirements. Remuxing not needed")
-			return nil
-		}
+	if plan.Process == processSkip {
+		return filePath, nil
+	}
 
-		// Extract subs
-		if s.CodecType == "subtitle" {
-			customNamingTag := ""
-			if s.Tags.HandlerName == "Hearing Impaired" {
-				customNamingTag = ".forced"
+	// A subtitle that can't be extracted doesn't stop the conversion. Bitmap subtitles are recognized once and written
+	// in every format.
+	recognized := make(map[int][]cue)
+	ocrErrs := make(map[int]error)
+	for _, sub := range plan.Subtitles {
+		if sub.OCR {
+			if _, ok := recognized[sub.Source]; !ok {
+				recognized[sub.Source], ocrErrs[sub.Source] = ocrSubtitle(ctx, filePath, sub, plan.Duration, profile.OCR)
 			}
-			switch s.Tags.Language {
-			case "eng", "en":
-				extractSubs(s.Tags.Language, filePath, subStreamIndex, customNamingTag)
-			case "spa", "es":
-				if strings.Contains(s.Tags.Title, "Latin America") || strings.Contains(s.Tags.Title, "Latinoamérica") {
-					extractSubs(s.Tags.Language, filePath, subStreamIndex, customNamingTag)
-				}
+			err = ocrErrs[sub.Source]
+			if err == nil {
+				err = writeCues(sub, recognized[sub.Source])
 			}
-
-			subStreamIndex++
-		}
-
-		// Add to amount of audio streams
-		if s.CodecType == "audio" {
-			totalAudioStreams++
+		} else {
+			err = extractSubs(ctx, filePath, sub)
 		}
-
-		// Check if there is an audio AAC 2.0 stream that is not default
-		if s.CodecType == "audio" && s.CodecName == "aac" && s.Channels == 2 {
-			audioStreamIndex = totalAudioStreams - 1
-			process = "disposition"
-		} else if s.CodecType == "audio" && s.Disposition.Default == 1 {
-			audioDefaultStreamIndex = totalAudioStreams - 1
+		if ctx.Err() != nil {
+			return filePath, ctx.Err()
 		}
-
-		if s.CodecType == "audio" && s.CodecName == "aac" && s.Channels != 2 && process == "" {
-			process = "channelToStereo"
-			audioStreamIndex = totalAudioStreams - 1
-		}
-
-		if s.CodecType == "audio" && s.CodecName != "aac" && process == "" {
-			process = "encode"
-			audioStreamIndex = totalAudioStreams - 1
-		}
-
-	}
-
-	// Run needed ffmpeg commands
-	if process == "disposition" {
-		// Rename file to .original
-		originalFile := fmt.Sprintf("%v.original", filePath)
-
-		err = os.Rename(filePath, originalFile)
 		if err != nil {
-			return err
+			log.Printf("Could not extract subtitle s:%v to %v: %v", sub.Source, sub.File, err)
 		}
+	}
 
-		changeDefaultAudioStream(totalAudioStreams, audioStreamIndex, audioDefaultStreamIndex, originalFile, filePath)
+	if plan.Process == processNone {
+		return filePath, nil
 	}
-	if process == "channelToStereo" {
-		// Rename file to .original
-		originalFile := fmt.Sprintf("%v.original", filePath)
 
-		err = os.Rename(filePath, originalFile)
-		if err != nil {
-			return err
-		}
+	// Rename file to .original
+	originalFile := fmt.Sprintf("%v.original", filePath)
 
-		createStereoAudioStream(totalAudioStreams, audioStreamIndex, originalFile, filePath)
+	err = os.Rename(filePath, originalFile)
+	if err != nil {
+		return filePath, err
 	}
-	if process == "encode" {
-		// Rename file to .original
-		originalFile := fmt.Sprintf("%v.original", filePath)
 
-		err = os.Rename(filePath, originalFile)
-		if err != nil {
-			return err
+	// Run needed ffmpeg command and check its output. The source file is restored if anything goes wrong.
+	err = remux(ctx, plan, profile, originalFile, progress)
+	if err == nil {
+		err = validateOutput(plan)
+	}
+	if err != nil {
+		restoreErr := restoreOriginal(plan, originalFile)
+		if restoreErr != nil {
+			return filePath, fmt.Errorf("converting %v: %v. Could not restore original file: %v", filePath, err, restoreErr)
 		}
-
-		encodeAudioStream(totalAudioStreams, audioStreamIndex, originalFile, filePath)
+		return filePath, fmt.Errorf("converting %v: %w", filePath, err)
 	}
 
-	return nil
+	return plan.Output, os.Remove(originalFile)
 }
 
-func extractSubs(language string, filePath string, subStreamIndex int, customNamingTag string) error {
+// ffmpeg encoders of the subtitle formats.
+var subtitleCodecs = map[string]string{
+	"vtt": "webvtt",
+	"srt": "srt",
+	"ass": "ass",
+}
 
-	fileName := filepath.Base(filePath)
-	fileDir := filepath.Dir(filePath)
+func extractSubs(ctx context.Context, filePath string, sub PlanSubtitle) error {
 
 	input := ffmpeg.Input(filePath, ffmpeg.KwArgs{"sub_charenc": "UTF-8"})
 
-	subtitleIndex := fmt.Sprintf("s:%v", subStreamIndex)
+	subtitleIndex := fmt.Sprintf("s:%v", sub.Source)
 	subtitle := input.Get(subtitleIndex)
 
-	// Convert subtitles to vtt
-	if language == "spa" {
-		language = "es"
+	// Convert subtitles to the requested format.
+	codec, ok := subtitleCodecs[sub.Format]
+	if !ok {
+		return fmt.Errorf("unsupported subtitle format: %v", sub.Format)
 	}
 
-	subtitleFileName := fmt.Sprintf("%v.%v%v.vtt", strings.TrimSuffix(fileName, filepath.Ext(fileName)), language, customNamingTag)
-
-	outputFileDir := fmt.Sprintf("%v/%v", fileDir, subtitleFileName)
-
-	codecSubtitleStream := fmt.Sprintf("c:s:%v", subStreamIndex)
-	out := ffmpeg.Output([]*ffmpeg.Stream{subtitle}, outputFileDir, ffmpeg.KwArgs{codecSubtitleStream: "webvtt"}).OverWriteOutput()
+	out := ffmpeg.Output([]*ffmpeg.Stream{subtitle}, sub.File, ffmpeg.KwArgs{"c:s": codec}).OverWriteOutput()
 
-	out.Run()
-	return nil
-}
-
-func changeDefaultAudioStream(totalAudioStreams, audioStreamIndex, audioDefaultStreamIndex int, originalFile, filePath string) error {
-	log.Printf("Changing a:%v to default", audioStreamIndex)
-
-	fileName := filepath.Base(filePath)
-	fileDir := filepath.Dir(filePath)
-
-	fileOutput := fmt.Sprintf("%v/%v.mp4", fileDir, strings.TrimSuffix(fileName, filepath.Ext(fileName)))
-
-	input := ffmpeg.Input(originalFile)
-
-	var streams []*ffmpeg.Stream
-
-	streams = append(streams, input.Get("v:0")) // Append video stream to slice
-	for i := 0; i <= totalAudioStreams-1; i++ {
-		stream := fmt.Sprintf("a:%v", i)
-
-		streams = append(streams, input.Get(stream))
+	err := runFFmpeg(ctx, out, sub.File, 0, nil)
+	if err != nil {
+		os.Remove(sub.File)
+		return err
 	}
-
-	newDefaultAudioStream := fmt.Sprintf("disposition:a:%v", audioStreamIndex)
-	oldDefaultAudioStream := fmt.Sprintf("disposition:a:%v", audioDefaultStreamIndex)
-
-	out := ffmpeg.Output(streams, fileOutput, ffmpeg.KwArgs{"c": "copy", newDefaultAudioStream: "default", oldDefaultAudioStream: 0, "movflags": "faststart"}).OverWriteOutput()
-	out.Run()
-
-	os.Remove(originalFile)
 	return nil
 }
 
-func createStereoAudioStream(totalAudioStreams, audioStreamIndex int, originalFile, filePath string) error {
-	log.Println("Creating AAC 2.0 audio stream.")
-
-	fileName := filepath.Base(filePath)
-	fileDir := filepath.Dir(filePath)
-
-	fileOutput := fmt.Sprintf("%v/%v.mp4", fileDir, strings.TrimSuffix(fileName, filepath.Ext(fileName)))
-
+// Write the streams of the plan from originalFile into the plan output.
+func remux(ctx context.Context, plan ConversionPlan, profile types.ConversionProfile, originalFile string, progress ProgressFunc) error {
 	input := ffmpeg.Input(originalFile)
 
+	// Chapters and global metadata are copied from the source. Copied streams keep their tags.
 	var streams []*ffmpeg.Stream
+	args := ffmpeg.KwArgs{"c": "copy", "disposition:a": 0, "map_metadata": 0, "map_chapters": 0}
 
-	streams = append(streams, input.Get("v")) // Get video stream
-	streams = append(streams, input.Get("a")) // Get all audio streams
+	audioIndex := 0
+	for _, s := range plan.Streams {
+		if s.Type == "video" {
+			streams = append(streams, input.Get(fmt.Sprintf("v:%v", s.Source)))
+			continue
+		}
 
-	for i := 0; i <= totalAudioStreams-1; i++ {
-		stream := fmt.Sprintf("a:%v", i)
+		streams = append(streams, input.Get(fmt.Sprintf("a:%v", s.Source)))
+		if s.Codec != "copy" {
+			log.Printf("Creating new %v %v channels audio stream from a:%v with %v downmix.", s.Codec, s.Channels, s.Source, s.Downmix)
+			args[fmt.Sprintf("c:a:%v", audioIndex)] = s.Codec
+			args[fmt.Sprintf("ac:a:%v", audioIndex)] = s.Channels
+
+			filter := s.Filter
+			if s.Downmix == downmixLoudnorm {
+				var err error
+				filter, err = loudnormFilter(ctx, originalFile, s.Source, s.Channels)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					log.Printf("Could not measure loudness of a:%v, using standard downmix: %v", s.Source, err)
+				}
+			}
+			if filter != "" {
+				args[fmt.Sprintf("filter:a:%v", audioIndex)] = filter
+			}
+		}
+		if s.Default {
+			args[fmt.Sprintf("disposition:a:%v", audioIndex)] = "default"
+		}
 
-		// only append the stream that is going to be downmixed to stereo and copied to a new audio stream
-		if i == audioStreamIndex {
-			streams = append(streams, input.Get(stream))
+		// A created stream would get the tags of its source, its title describes another codec. mp4 players read the
+		// title from the handler name.
+		if s.Codec != "copy" {
+			args[fmt.Sprintf("metadata:s:a:%v", audioIndex)] = []string{"title=" + s.Title, "handler_name=" + s.Title, "language=" + iso6392Code(s.Language)}
+		} else if s.Title != "" && container(plan.Output) == "mp4" {
+			args[fmt.Sprintf("metadata:s:a:%v", audioIndex)] = "handler_name=" + s.Title
 		}
+		audioIndex++
 	}
 
-	addedAudioStream := fmt.Sprintf("c:a:%v", totalAudioStreams)
-
-	out := ffmpeg.Output(streams, fileOutput, ffmpeg.KwArgs{"c:v": "copy", "ac": 2, addedAudioStream: "copy", "disposition:a": 0, "disposition:a:0": "default", "movflags": "faststart"}).OverWriteOutput()
-	out.Run()
+	out := ffmpeg.Output(streams, plan.Output, outputArgs(profile, plan, args)).OverWriteOutput()
 
-	os.Remove(originalFile)
-
-	return nil
+	return runFFmpeg(ctx, out, plan.Output, plan.Duration, progress)
 }
 
-func encodeAudioStream(totalAudioStreams, audioStreamIndex int, originalFile, filePath string) error {
-	log.Println("Converting and creating new AAC 2.0 audio stream.")
-
+// Path of the converted file, with the extension of the container.
+func outputFile(filePath string, container string) string {
 	fileName := filepath.Base(filePath)
 	fileDir := filepath.Dir(filePath)
 
-	fileOutput := fmt.Sprintf("%v/%v.mp4", fileDir, strings.TrimSuffix(fileName, filepath.Ext(fileName)))
-
-	input := ffmpeg.Input(originalFile)
-
-	var streams []*ffmpeg.Stream
-
-	streams = append(streams, input.Get("v")) // Get video stream
-	streams = append(streams, input.Get("a")) // Get all audio streams
+	return fmt.Sprintf("%v/%v.%v", fileDir, strings.TrimSuffix(fileName, filepath.Ext(fileName)), container)
+}
 
-	for i := 0; i <= totalAudioStreams-1; i++ {
-		stream := fmt.Sprintf("a:%v", i)
+// Add the container and video transcoding arguments to args.
+func outputArgs(profile types.ConversionProfile, plan ConversionPlan, args ffmpeg.KwArgs) ffmpeg.KwArgs {
+	if container(plan.Output) == "mp4" {
+		args["movflags"] = "faststart"
+	}
 
-		// only append the stream that is going to be copied
-		if i == audioStreamIndex {
-			streams = append(streams, input.Get(stream))
+	if plan.TranscodeVideo {
+		log.Println("Transcoding video stream to 8-bit h264.")
+		args["c:v"] = "libx264"
+		args["crf"] = profile.VideoCRF
+		args["preset"] = profile.VideoPreset
+		args["profile:v"] = "high"
+		args["pix_fmt"] = "yuv420p"
+		var filters []string
+		if plan.ToneMap {
+			filters = append(filters, toneMapFilter(profile.ToneMapping))
+		}
+		if plan.ScaleWidth > 0 {
+			filters = append(filters, fmt.Sprintf("scale=%v:-2", plan.ScaleWidth))
+		}
+		if len(filters) > 0 {
+			args["vf"] = strings.Join(filters, ",")
 		}
 	}
 
-	addedAudioStream := fmt.Sprintf("c:a:%v", totalAudioStreams)
+	return args
+}
 
-	out := ffmpeg.Output(streams, fileOutput, ffmpeg.KwArgs{"c:v": "copy", "c:a:0": "aac", "ac": 2, addedAudioStream: "copy", "disposition:a": 0, "disposition:a:0": "default", "movflags": "faststart"}).OverWriteOutput()
-	out.Run()
+// Filter converting HDR (PQ/HLG, bt2020) video to SDR bt709 in software with zscale.
+func toneMapFilter(algorithm string) string {
+	return "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=" + algorithm + ":desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+}
 
-	os.Remove(originalFile)
+// Bit depth of the pixel formats with more than 8 bits per component, before their endianness: yuv420p10le, p010le,
+// gray12be... yuv410p is 8-bit.
+var highBitDepth = regexp.MustCompile(`(10|12|14|16)(le|be)$`)
 
-	return nil
+func is10Bit(pixFmt string) bool {
+	return highBitDepth.MatchString(pixFmt)
+}
+
+// Whether the transfer characteristics of a video stream are HDR: PQ (HDR10/Dolby Vision) or HLG.
+func isHDR(colorTransfer string) bool {
+	return colorTransfer == "smpte2084" || colorTransfer == "arib-std-b67"
 }
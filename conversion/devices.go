@@ -0,0 +1,245 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Built-in device profiles. They describe what the devices direct-play without the media server transcoding.
+var devices = map[string]types.DeviceProfile{
+	"chromecast": {
+		Containers:       []string{"mp4", "webm"},
+		VideoCodecs:      []string{"h264", "vp8"},
+		H264Profiles:     []string{"Constrained Baseline", "Baseline", "Main", "High"},
+		MaxH264Level:     42,
+		MaxWidth:         1920,
+		AudioCodecs:      []string{"aac", "mp3", "opus", "vorbis", "flac"},
+		MaxAudioChannels: 2,
+		SubtitleFormats:  []string{"vtt"},
+	},
+	"chromecast-4k": {
+		Containers:       []string{"mp4", "mkv", "webm"},
+		VideoCodecs:      []string{"h264", "hevc", "vp9"},
+		H264Profiles:     []string{"Constrained Baseline", "Baseline", "Main", "High"},
+		MaxH264Level:     52,
+		MaxWidth:         3840,
+		TenBit:           true,
+		HDR:              true,
+		AudioCodecs:      []string{"aac", "ac3", "eac3", "mp3", "opus", "flac"},
+		MaxAudioChannels: 6,
+		SubtitleFormats:  []string{"vtt", "srt"},
+	},
+	"firetv": {
+		Containers:       []string{"mp4", "mkv"},
+		VideoCodecs:      []string{"h264", "hevc", "vp9"},
+		H264Profiles:     []string{"Constrained Baseline", "Baseline", "Main", "High"},
+		MaxH264Level:     52,
+		MaxWidth:         3840,
+		TenBit:           true,
+		HDR:              true,
+		AudioCodecs:      []string{"aac", "ac3", "eac3", "mp3", "opus", "flac"},
+		MaxAudioChannels: 8,
+		SubtitleFormats:  []string{"srt", "vtt", "ass"},
+	},
+	"browser": {
+		Containers:       []string{"mp4", "webm"},
+		VideoCodecs:      []string{"h264", "vp9", "av1"},
+		H264Profiles:     []string{"Constrained Baseline", "Baseline", "Main", "High"},
+		MaxH264Level:     51,
+		MaxWidth:         3840,
+		AudioCodecs:      []string{"aac", "mp3", "opus", "flac"},
+		MaxAudioChannels: 2,
+		SubtitleFormats:  []string{"vtt"},
+	},
+	"appletv": {
+		Containers:       []string{"mp4", "mov"},
+		VideoCodecs:      []string{"h264", "hevc"},
+		H264Profiles:     []string{"Constrained Baseline", "Baseline", "Main", "High"},
+		MaxH264Level:     52,
+		MaxWidth:         3840,
+		TenBit:           true,
+		HDR:              true,
+		AudioCodecs:      []string{"aac", "ac3", "eac3", "alac", "mp3"},
+		MaxAudioChannels: 8,
+		SubtitleFormats:  []string{"vtt"},
+	},
+}
+
+// Whether a device can direct-play a file and, if not, why.
+type DeviceReport struct {
+	Device     string   `json:"device"`
+	DirectPlay bool     `json:"directPlay"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+// Get the profiles of the devices, from the config or else the built-in ones: chromecast, chromecast-4k, firetv,
+// browser and appletv.
+func DeviceProfiles(conf types.TomlConfig, names []string) ([]types.DeviceProfile, error) {
+	var profiles []types.DeviceProfile
+	for _, name := range names {
+		d, ok := conf.Conversion.Devices[name]
+		if !ok {
+			d, ok = devices[name]
+		}
+		if !ok {
+			return nil, errors.New("device profile not found: " + name)
+		}
+		d.Name = name
+		profiles = append(profiles, d)
+	}
+	return profiles, nil
+}
+
+// Check which devices can direct-play a file from its container, main video stream and default audio stream.
+func checkDevices(filePath string, video *ProbeStream, audio *audioStream, deviceProfiles []types.DeviceProfile, subtitleFormats []string) []DeviceReport {
+	var reports []DeviceReport
+	for _, d := range deviceProfiles {
+		var issues []string
+		if !containerFits(filePath, d) {
+			issues = append(issues, "container "+container(filePath))
+		}
+		if video != nil {
+			issues = append(issues, videoIssues(*video, d)...)
+		}
+		if audio != nil && !audioFits(*audio, d) {
+			issues = append(issues, fmt.Sprintf("audio %v %v channels", audio.Codec, audio.Channels))
+		}
+		if len(subtitleFormats) > 0 && !anyFold(d.SubtitleFormats, subtitleFormats) {
+			issues = append(issues, "subtitles "+strings.Join(subtitleFormats, ", "))
+		}
+		reports = append(reports, DeviceReport{Device: d.Name, DirectPlay: len(issues) == 0, Issues: issues})
+	}
+	return reports
+}
+
+// What a device can't play of a video stream.
+func videoIssues(s ProbeStream, d types.DeviceProfile) []string {
+	var issues []string
+	if !containsFold(d.VideoCodecs, s.CodecName) {
+		issues = append(issues, "video codec "+s.CodecName)
+	}
+	if s.CodecName == "h264" {
+		if len(d.H264Profiles) > 0 && !containsFold(d.H264Profiles, s.Profile) {
+			issues = append(issues, "h264 profile "+s.Profile)
+		}
+		if d.MaxH264Level > 0 && s.Level > d.MaxH264Level {
+			issues = append(issues, fmt.Sprintf("h264 level %v", s.Level))
+		}
+	}
+	if d.MaxWidth > 0 && s.Width > d.MaxWidth {
+		issues = append(issues, fmt.Sprintf("width %v", s.Width))
+	}
+	if is10Bit(s.PixFmt) && !d.TenBit {
+		issues = append(issues, "10-bit video")
+	}
+	if isHDR(s.ColorTransfer) && !d.HDR {
+		issues = append(issues, "HDR video")
+	}
+	return issues
+}
+
+func audioFits(a audioStream, d types.DeviceProfile) bool {
+	return containsFold(d.AudioCodecs, a.Codec) && (d.MaxAudioChannels == 0 || a.Channels <= d.MaxAudioChannels)
+}
+
+func containerFits(filePath string, d types.DeviceProfile) bool {
+	return containsFold(d.Containers, container(filePath))
+}
+
+// Container of a file from its extension.
+func container(filePath string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	if ext == "m4v" {
+		return "mp4"
+	}
+	return ext
+}
+
+// Whether every device fits.
+func allDevices(deviceProfiles []types.DeviceProfile, fits func(d types.DeviceProfile) bool) bool {
+	for _, d := range deviceProfiles {
+		if !fits(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Smallest MaxWidth of the devices. 0 if none has a limit.
+func maxWidth(deviceProfiles []types.DeviceProfile) int {
+	width := 0
+	for _, d := range deviceProfiles {
+		if d.MaxWidth > 0 && (width == 0 || d.MaxWidth < width) {
+			width = d.MaxWidth
+		}
+	}
+	return width
+}
+
+func anyFold(s []string, values []string) bool {
+	for _, v := range values {
+		if containsFold(s, subtitleFormat(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Codec and channels of the audio track created for the devices. The profile ones are kept if every device plays them,
+// otherwise the first codec of the first device that every device plays is used, with the fewest max channels.
+func deviceAudio(deviceProfiles []types.DeviceProfile, codec string, channels int) (string, int, error) {
+	if len(deviceProfiles) == 0 {
+		return codec, channels, nil
+	}
+
+	if !allDevices(deviceProfiles, func(d types.DeviceProfile) bool { return containsFold(d.AudioCodecs, codec) }) {
+		codec = ""
+		for _, c := range deviceProfiles[0].AudioCodecs {
+			if allDevices(deviceProfiles, func(d types.DeviceProfile) bool { return containsFold(d.AudioCodecs, c) }) {
+				codec = c
+				break
+			}
+		}
+		if codec == "" {
+			return "", 0, errors.New("no audio codec is played by every device")
+		}
+	}
+
+	for _, d := range deviceProfiles {
+		if d.MaxAudioChannels > 0 && channels > d.MaxAudioChannels {
+			channels = d.MaxAudioChannels
+		}
+	}
+	return codec, channels, nil
+}
+
+// Containers the planned streams can be written to: the copied or h264 video and the copied or created audio. webm
+// only holds VP8/VP9/AV1 and Opus/Vorbis.
+var outputContainers = []string{"mp4", "mkv", "mov"}
+
+// Container played by every device: the one of the source if possible, then the profile one, otherwise the first of
+// the first device that every device plays and that can hold the planned streams.
+func deviceContainer(deviceProfiles []types.DeviceProfile, source, container string) (string, error) {
+	fits := func(c string) bool {
+		return containsFold(outputContainers, c) && allDevices(deviceProfiles, func(d types.DeviceProfile) bool { return containsFold(d.Containers, c) })
+	}
+	if len(deviceProfiles) == 0 {
+		return container, nil
+	}
+	if fits(source) {
+		return source, nil
+	}
+	if fits(container) {
+		return container, nil
+	}
+
+	for _, c := range deviceProfiles[0].Containers {
+		if fits(c) {
+			return c, nil
+		}
+	}
+	return "", errors.New("no container that can hold the planned streams is played by every device")
+}
@@ -0,0 +1,161 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"reflect"
+	"testing"
+)
+
+func testDevices(t *testing.T, names ...string) []types.DeviceProfile {
+	t.Helper()
+	profiles, err := DeviceProfiles(types.TomlConfig{}, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profiles
+}
+
+func TestVideoIssues(t *testing.T) {
+	h264 := ProbeStream{CodecName: "h264", Profile: "High", Level: 41, PixFmt: "yuv420p", Width: 1920}
+	hevcHDR := ProbeStream{CodecName: "hevc", Profile: "Main 10", PixFmt: "yuv420p10le", Width: 3840, ColorTransfer: "smpte2084"}
+
+	tests := []struct {
+		name   string
+		stream ProbeStream
+		device string
+		issues []string
+	}{
+		{"h264 on chromecast", h264, "chromecast", nil},
+		{"hevc HDR on chromecast", hevcHDR, "chromecast", []string{"video codec hevc", "width 3840", "10-bit video", "HDR video"}},
+		{"hevc HDR on chromecast-4k", hevcHDR, "chromecast-4k", nil},
+		{"h264 high level on chromecast", ProbeStream{CodecName: "h264", Profile: "High", Level: 51, PixFmt: "yuv420p", Width: 1920}, "chromecast", []string{"h264 level 51"}},
+		{"h264 high 10 on browser", ProbeStream{CodecName: "h264", Profile: "High 10", Level: 41, PixFmt: "yuv420p10le", Width: 1920}, "browser", []string{"h264 profile High 10", "10-bit video"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := videoIssues(tt.stream, testDevices(t, tt.device)[0])
+			if !reflect.DeepEqual(got, tt.issues) {
+				t.Errorf("issues = %q, want %q", got, tt.issues)
+			}
+		})
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	video := &ProbeStream{CodecName: "hevc", Profile: "Main 10", PixFmt: "yuv420p10le", Width: 3840, ColorTransfer: "smpte2084"}
+	audio := &audioStream{Codec: "eac3", Channels: 6}
+
+	reports := checkDevices("/dl/Movie.mkv", video, audio, testDevices(t, "chromecast", "firetv"), []string{"srt"})
+
+	want := []DeviceReport{
+		{Device: "chromecast", Issues: []string{"container mkv", "video codec hevc", "width 3840", "10-bit video", "HDR video", "audio eac3 6 channels", "subtitles srt"}},
+		{Device: "firetv", DirectPlay: true},
+	}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("reports = %+v, want %+v", reports, want)
+	}
+}
+
+func TestDeviceAudio(t *testing.T) {
+	tests := []struct {
+		name     string
+		devices  []string
+		codec    string
+		channels int
+		want     string
+		wantCh   int
+	}{
+		{"no devices", nil, "eac3", 6, "eac3", 6},
+		{"played by every device", []string{"firetv", "appletv"}, "eac3", 6, "eac3", 6},
+		{"codec and channels of the devices", []string{"chromecast"}, "eac3", 6, "aac", 2},
+		{"fewest channels of the devices", []string{"chromecast-4k", "firetv"}, "ac3", 8, "ac3", 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, channels, err := deviceAudio(testDevices(t, tt.devices...), tt.codec, tt.channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tt.want || channels != tt.wantCh {
+				t.Errorf("audio = %v %v, want %v %v", codec, channels, tt.want, tt.wantCh)
+			}
+		})
+	}
+
+	devices := []types.DeviceProfile{{AudioCodecs: []string{"aac"}}, {AudioCodecs: []string{"ac3"}}}
+	if _, _, err := deviceAudio(devices, "aac", 2); err == nil {
+		t.Error("no error without a codec common to every device")
+	}
+}
+
+func TestDeviceContainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		devices   []string
+		source    string
+		container string
+		want      string
+	}{
+		{"no devices", nil, "avi", "mkv", "mkv"},
+		{"source played by every device", []string{"firetv", "chromecast-4k"}, "mkv", "mp4", "mkv"},
+		{"profile container played by every device", []string{"chromecast", "firetv"}, "avi", "mp4", "mp4"},
+		{"container of the devices", []string{"chromecast", "firetv"}, "mkv", "mkv", "mp4"},
+		{"webm can't hold the planned streams", []string{"browser"}, "webm", "mkv", "mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := deviceContainer(testDevices(t, tt.devices...), tt.source, tt.container)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.want {
+				t.Errorf("container = %v, want %v", c, tt.want)
+			}
+		})
+	}
+
+	devices := []types.DeviceProfile{{Containers: []string{"mkv"}}, {Containers: []string{"mp4"}}}
+	if _, err := deviceContainer(devices, "mkv", "mkv"); err == nil {
+		t.Error("no error without a container common to every device")
+	}
+	devices = []types.DeviceProfile{{Containers: []string{"webm"}}}
+	if _, err := deviceContainer(devices, "mkv", "mp4"); err == nil {
+		t.Error("no error with only webm")
+	}
+}
+
+func TestPlanDeviceAudio(t *testing.T) {
+	profile := testProfile()
+	profile.AudioCodec = "eac3"
+	profile.AudioChannels = 6
+	profile.DeviceProfiles = testDevices(t, "chromecast")
+
+	plan, err := Plan(probeFixture(t, "h264_dts_subs"), "/dl/Movie.mkv", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chromecast plays neither eac3 nor 6 channels, the new track must be one it plays.
+	want := []string{"v:0 copy", "a:0 aac 2 default"}
+	if got := planStreams(plan); plan.Process != processEncode || !reflect.DeepEqual(got, want) {
+		t.Errorf("process = %v, streams = %q, want %v %q", plan.Process, got, processEncode, want)
+	}
+}
+
+func TestPlanDeviceSourceContainer(t *testing.T) {
+	profile := testProfile()
+	profile.DeviceProfiles = testDevices(t, "firetv")
+
+	plan, err := Plan(probeFixture(t, "h264_ac3_aac"), "/dl/Movie.mkv", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// firetv plays mkv, the source container is kept instead of the mp4 of the profile.
+	if plan.Output != "/dl/Movie.mkv" {
+		t.Errorf("output = %v, want /dl/Movie.mkv", plan.Output)
+	}
+}
@@ -0,0 +1,113 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Downmix methods of a created audio stream.
+const (
+	downmixStandard  = "standard"  // ffmpeg default downmix, -ac.
+	downmixNightmode = "nightmode" // Center channel at full level and the rest at 30%, for louder dialogue.
+	downmixLoudnorm  = "loudnorm"  // Standard downmix normalized to loudnessTarget with two loudnorm passes.
+)
+
+// EBU R128 targets of the loudnorm downmix.
+const (
+	loudnessTarget   = -16.0 // Integrated loudness, LUFS.
+	truePeakTarget   = -1.5  // dBTP.
+	loudnessRangeMax = 11.0  // LU.
+)
+
+// Surround channels of the channel layouts the nightmode matrix handles.
+var nightmodeSurrounds = map[string][2]string{
+	"5.1":       {"BL", "BR"},
+	"5.1(side)": {"SL", "SR"},
+	"7.1":       {"SL", "SR"},
+}
+
+// Nightmode downmix filter for a channel layout. ok is false for layouts the matrix doesn't handle.
+func nightmodeFilter(layout string) (filter string, ok bool) {
+	surrounds, ok := nightmodeSurrounds[layout]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("pan=stereo|FL=FC+0.30*FL+0.30*%v|FR=FC+0.30*FR+0.30*%v", surrounds[0], surrounds[1]), true
+}
+
+// Work out how a created audio stream is downmixed from a source stream with channels and layout, and the audio filter
+// that does it, if any. Filters that need the source measured, loudnorm, are set when converting.
+func downmix(method string, channels int, layout string, outChannels int) (applied string, filter string, reason string) {
+	switch method {
+	case downmixNightmode:
+		if outChannels != 2 || channels <= 2 {
+			return downmixStandard, "", "nightmode only downmixes surround to stereo"
+		}
+		filter, ok := nightmodeFilter(layout)
+		if !ok {
+			return downmixStandard, "", fmt.Sprintf("nightmode doesn't handle the %v channel layout", layout)
+		}
+		return downmixNightmode, filter, "center channel boosted for dialogue"
+	case downmixLoudnorm:
+		return downmixLoudnorm, "", fmt.Sprintf("normalized to %v LUFS", loudnessTarget)
+	default:
+		return downmixStandard, "", ""
+	}
+}
+
+// Layouts the loudnorm downmix converts to before measuring, by channels.
+var channelLayouts = map[int]string{
+	1: "mono",
+	2: "stereo",
+}
+
+// loudnorm measurements of the first pass.
+type loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// Measure the loudness of audio stream a:source as the loudnorm downmix sees it and get the filter of the second
+// pass, which normalizes it linearly.
+func loudnormFilter(ctx context.Context, file string, source, channels int) (string, error) {
+	pre := ""
+	if layout, ok := channelLayouts[channels]; ok {
+		pre = "aformat=channel_layouts=" + layout + ","
+	}
+	target := fmt.Sprintf("loudnorm=I=%v:TP=%v:LRA=%v", loudnessTarget, truePeakTarget, loudnessRangeMax)
+
+	log.Printf("Measuring loudness of a:%v.", source)
+	out := ffmpeg.Input(file).Get(fmt.Sprintf("a:%v", source)).Output("-", ffmpeg.KwArgs{"af": pre + target + ":print_format=json", "f": "null"})
+	stderr, err := runFFmpegOutput(ctx, out, file, 0, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// loudnorm prints its measurements as the last JSON object of the output.
+	start := strings.LastIndex(stderr, "{")
+	end := strings.LastIndex(stderr, "}")
+	if start == -1 || end < start {
+		return "", errors.New("loudnorm measurements not found")
+	}
+	var l loudness
+	err = json.Unmarshal([]byte(stderr[start:end+1]), &l)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(l.InputI, "inf") {
+		return "", errors.New("audio stream is silent")
+	}
+
+	// loudnorm resamples to 192 kHz, aresample brings it back.
+	return fmt.Sprintf("%v%v:measured_I=%v:measured_TP=%v:measured_LRA=%v:measured_thresh=%v:offset=%v:linear=true,aresample=48000",
+		pre, target, l.InputI, l.InputTP, l.InputLRA, l.InputThresh, l.TargetOffset), nil
+}
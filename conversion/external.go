@@ -0,0 +1,314 @@
+package conversion
+
+import (
+	"context"
+	"debridGo/types"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Extensions of the external subtitle files imported from a download. .sub files are only imported when they are text
+// (MicroDVD), VobSub .sub files come with an .idx.
+var externalSubtitleExtensions = map[string]bool{
+	".srt": true,
+	".ass": true,
+	".ssa": true,
+	".vtt": true,
+	".sub": true,
+}
+
+// Language names found in subtitle file names, e.g. "2_English.srt".
+var languageNames = map[string]string{
+	"english":    "en",
+	"spanish":    "es",
+	"español":    "es",
+	"espanol":    "es",
+	"latino":     "es",
+	"castellano": "es",
+	"french":     "fr",
+	"français":   "fr",
+	"german":     "de",
+	"deutsch":    "de",
+	"italian":    "it",
+	"italiano":   "it",
+	"portuguese": "pt",
+	"português":  "pt",
+	"brazilian":  "pt",
+	"dutch":      "nl",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"chinese":    "zh",
+	"russian":    "ru",
+}
+
+// Common words used to detect the language of a subtitle from its text.
+var stopWords = map[string][]string{
+	"en": {"the", "you", "and", "is", "to", "what", "it", "that", "this", "of"},
+	"es": {"que", "de", "no", "el", "la", "es", "y", "en", "lo", "por"},
+	"fr": {"je", "de", "est", "pas", "le", "vous", "la", "et", "que", "un"},
+	"de": {"ich", "die", "und", "nicht", "das", "du", "ist", "sie", "der", "es"},
+	"it": {"che", "di", "non", "il", "è", "la", "un", "per", "mi", "sono"},
+	"pt": {"que", "não", "de", "o", "e", "é", "um", "você", "eu", "para"},
+	"nl": {"de", "het", "een", "ik", "je", "niet", "dat", "is", "en", "van"},
+}
+
+var episodeTag = regexp.MustCompile(`(?i)s\d{1,2}e\d{1,3}`)
+
+// Find the external subtitle files of a download, give them the name of the video they belong to with their language
+// and tags, e.g. "Movie.en.forced.srt", and put them next to it. Subtitles whose language isn't in the profile are left
+// where they are. With ConvertExternalSubtitles they are also written in the subtitle formats of the profile.
+// Returns the paths of the imported subtitles.
+func ImportSubtitles(ctx context.Context, saveDir string, videos []string, profile types.ConversionProfile) ([]string, error) {
+	var imported []string
+
+	err := filepath.WalkDir(saveDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !externalSubtitleExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		files, err := importSubtitle(ctx, path, videos, profile)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Could not import subtitle %v: %v", path, err)
+			return nil
+		}
+		imported = append(imported, files...)
+		return nil
+	})
+
+	return imported, err
+}
+
+func importSubtitle(ctx context.Context, path string, videos []string, profile types.ConversionProfile) ([]string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".sub" {
+		if _, err := os.Stat(strings.TrimSuffix(path, filepath.Ext(path)) + ".idx"); err == nil {
+			return nil, fmt.Errorf("VobSub subtitles are not imported")
+		}
+	}
+
+	video := subtitleVideo(path, videos)
+	if video == "" {
+		return nil, fmt.Errorf("no video matches the subtitle")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the part of the name after the video name has tags, the title of the video could look like a language code.
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	videoName := strings.TrimSuffix(filepath.Base(video), filepath.Ext(video))
+	if len(name) > len(videoName) && strings.EqualFold(name[:len(videoName)], videoName) {
+		name = name[len(videoName):]
+	}
+	language, ok := subtitleLanguage(name, filepath.Base(filepath.Dir(path)), data, profile.Languages)
+	if !ok {
+		return nil, fmt.Errorf("language not detected or not in the profile")
+	}
+
+	tokens := nameTokens(name)
+	tag := subtitleTag(containsFold(tokens, "default"), containsFold(tokens, "forced"), containsFold(tokens, "sdh") || containsFold(tokens, "hi") || containsFold(tokens, "cc"), profile.HearingImpairedTag)
+
+	// MicroDVD .sub files are written as srt, few players read them.
+	format := strings.TrimPrefix(ext, ".")
+	if format == "sub" {
+		format = "srt"
+	}
+
+	var files []string
+	dst := subtitleFile(video, languageCode(language), tag, format)
+	if _, err := os.Stat(dst); err == nil && dst != path {
+		return nil, fmt.Errorf("%v already exists", dst)
+	}
+
+	if dst == path {
+		err = nil
+	} else if ext == ".sub" {
+		err = convertSubtitle(ctx, path, dst, data)
+		if err == nil {
+			err = os.Remove(path)
+		}
+	} else {
+		err = os.Rename(path, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Imported subtitle %v as %v.", path, dst)
+	files = append(files, dst)
+
+	if !profile.ConvertExternalSubtitles {
+		return files, nil
+	}
+
+	for _, f := range profile.SubtitleFormats {
+		f = subtitleFormat(f)
+		file := subtitleFile(video, languageCode(language), tag, f)
+		if f == format {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			continue
+		}
+		err = convertSubtitle(ctx, dst, file, data)
+		if err != nil {
+			log.Printf("Could not convert subtitle %v to %v: %v", dst, f, err)
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// Video a subtitle belongs to: the only video of the download, the one the subtitle or its directory is named after,
+// or the one with the same episode number.
+func subtitleVideo(path string, videos []string) string {
+	if len(videos) == 1 {
+		return videos[0]
+	}
+
+	name := strings.ToLower(filepath.Base(path))
+	dir := strings.ToLower(filepath.Base(filepath.Dir(path)))
+	episode := strings.ToLower(episodeTag.FindString(filepath.Base(filepath.Dir(path)) + " " + filepath.Base(path)))
+
+	for _, v := range videos {
+		base := strings.ToLower(strings.TrimSuffix(filepath.Base(v), filepath.Ext(v)))
+		if strings.HasPrefix(name, base) || dir == base {
+			return v
+		}
+	}
+	if episode == "" {
+		return ""
+	}
+	for _, v := range videos {
+		if strings.ToLower(episodeTag.FindString(filepath.Base(v))) == episode {
+			return v
+		}
+	}
+	return ""
+}
+
+// Profile language of a subtitle, from the language codes or names in its file or directory name, otherwise from its
+// text. Tags are usually at the end of the name, so the last tokens are checked first.
+func subtitleLanguage(name, dir string, data []byte, languages []string) (string, bool) {
+	tokens := append(nameTokens(dir), nameTokens(name)...)
+	for i := len(tokens) - 1; i >= 0; i-- {
+		token := tokens[i]
+		code := languageCode(token)
+		if c, ok := languageNames[strings.ToLower(token)]; ok {
+			code = c
+		} else if !isLanguageCode(code) {
+			continue
+		}
+		if language, ok := matchLanguage(languages, code, name+" "+dir); ok {
+			return language, true
+		}
+	}
+
+	code := detectLanguage(data)
+	if code == "" {
+		return "", false
+	}
+	return matchLanguage(languages, code, name+" "+dir)
+}
+
+// Language of a subtitle text: the one whose common words appear the most.
+func detectLanguage(data []byte) string {
+	if !utf8.Valid(data) {
+		data = []byte(latin1ToUTF8(data))
+	}
+
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(string(data)), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r > 127)
+	}) {
+		for code, words := range stopWords {
+			if containsString(words, word) {
+				counts[code]++
+			}
+		}
+	}
+
+	best := ""
+	for code, n := range counts {
+		if n > counts[best] || (n == counts[best] && code < best) {
+			best = code
+		}
+	}
+	// Too few matches to tell.
+	if counts[best] < 20 {
+		return ""
+	}
+	return best
+}
+
+// Write a subtitle file in the format of the dst extension. Text that isn't UTF-8 is read as Windows-1252.
+func convertSubtitle(ctx context.Context, src, dst string, data []byte) error {
+	charenc := "UTF-8"
+	if !utf8.Valid(data) {
+		charenc = "CP1252"
+	}
+
+	codec, ok := subtitleCodecs[strings.TrimPrefix(filepath.Ext(dst), ".")]
+	if !ok {
+		return fmt.Errorf("unsupported subtitle format: %v", filepath.Ext(dst))
+	}
+
+	out := ffmpeg.Input(src, ffmpeg.KwArgs{"sub_charenc": charenc}).Output(dst, ffmpeg.KwArgs{"c:s": codec}).OverWriteOutput()
+	err := runFFmpeg(ctx, out, dst, 0, nil)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Words of a file name, e.g. "Movie.2019.en.forced" -> [Movie 2019 en forced].
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == ' ' || r == '[' || r == ']' || r == '(' || r == ')'
+	})
+}
+
+// Whether code is a two letter code of a language known to debridGo.
+func isLanguageCode(code string) bool {
+	for _, c := range iso6392 {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func latin1ToUTF8(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func containsString(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Max difference in seconds between the durations of the source and the converted file, on top of 1% of the duration.
+const durationTolerance = 2.0
+
+// Time ffmpeg has to exit after a conversion is cancelled before it is killed.
+const cancelGracePeriod = 10 * time.Second
+
+// Run an ffmpeg command. On failure the error includes the last lines ffmpeg wrote to stderr. The progress of the
+// command is reported to progress, if not nil, based on duration. Cancelling ctx stops ffmpeg: it is asked to quit
+// and killed if it's still running after cancelGracePeriod.
+func runFFmpeg(ctx context.Context, out *ffmpeg.Stream, file string, duration float64, progress ProgressFunc) error {
+	_, err := runFFmpegOutput(ctx, out, file, duration, progress)
+	return err
+}
+
+// Run an ffmpeg command like runFFmpeg and get what it wrote to stderr.
+func runFFmpegOutput(ctx context.Context, out *ffmpeg.Stream, file string, duration float64, progress ProgressFunc) (string, error) {
+	args := append([]string{"-nostats", "-progress", "pipe:1"}, out.GetArgs()...)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return "", err
+	}
+
+	// Stop ffmpeg when ctx is cancelled. SIGINT lets it close the output file before exiting.
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(os.Interrupt)
+		case <-exited:
+			return
+		}
+		select {
+		case <-time.After(cancelGracePeriod):
+			cmd.Process.Kill()
+		case <-exited:
+		}
+	}()
+
+	readProgress(stdout, file, duration, progress)
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return stderr.String(), fmt.Errorf("ffmpeg stopped: %w", ctx.Err())
+	}
+	if err != nil {
+		return stderr.String(), fmt.Errorf("ffmpeg failed: %w: %v", err, lastLines(stderr.String(), 10))
+	}
+	return stderr.String(), nil
+}
+
+// Probe the converted file and check it has the streams of the plan and the duration of the source.
+func validateOutput(plan ConversionPlan) error {
+	data, err := ffmpeg.Probe(plan.Output)
+	if err != nil {
+		return fmt.Errorf("could not probe converted file: %w", err)
+	}
+
+	var vFileInfo VideoFileInfoProbe
+	err = json.Unmarshal([]byte(data), &vFileInfo)
+	if err != nil {
+		return err
+	}
+
+	want := make(map[string]int)
+	for _, s := range plan.Streams {
+		want[s.Type]++
+	}
+	got := make(map[string]int)
+	for _, s := range vFileInfo.Streams {
+		got[s.CodecType]++
+	}
+	for _, t := range []string{"video", "audio"} {
+		if got[t] != want[t] {
+			return fmt.Errorf("converted file has %v %v streams, %v expected", got[t], t, want[t])
+		}
+	}
+
+	if plan.Duration > 0 {
+		duration, _ := strconv.ParseFloat(vFileInfo.Format.Duration, 64)
+		if math.Abs(duration-plan.Duration) > durationTolerance+plan.Duration/100 {
+			return fmt.Errorf("converted file lasts %.1fs, the source %.1fs", duration, plan.Duration)
+		}
+	}
+
+	return nil
+}
+
+// Put the source file back in place of a failed conversion.
+func restoreOriginal(plan ConversionPlan, originalFile string) error {
+	err := os.Remove(plan.Output)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return os.Rename(originalFile, plan.File)
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
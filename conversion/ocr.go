@@ -0,0 +1,221 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debridGo/types"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Frames per second bitmap subtitles are sampled at for OCR. It is also the precision of the subtitle timings.
+const ocrFrameRate = 10
+
+// Tesseract models of languages whose model isn't named after their ISO 639-2 terminologic code.
+var tesseractModels = map[string]string{
+	"zh": "chi_sim",
+}
+
+// Subtitle recognized from a bitmap.
+type cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Convert a bitmap subtitle stream to text. Every change of the subtitle is rendered by ffmpeg into a PNG, black text
+// on white, named after its timestamp, and then recognized by tesseract.
+func ocrSubtitle(ctx context.Context, filePath string, sub PlanSubtitle, duration float64, ocr types.OCR) ([]cue, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(filePath), ".ocr-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	background := ffmpeg.Input(fmt.Sprintf("color=c=black:s=%vx%v:r=%v:d=%v", sub.Width, sub.Height, ocrFrameRate, duration), ffmpeg.KwArgs{"f": "lavfi"})
+	subtitle := ffmpeg.Input(filePath).Get(fmt.Sprintf("s:%v", sub.Source))
+
+	// mpdecimate drops the frames where the subtitle doesn't change. With frame_pts the file names are the timestamps
+	// of the frames in 1/ocrFrameRate units.
+	out := background.Overlay(subtitle, "pass").
+		Filter("mpdecimate", ffmpeg.Args{}).
+		Filter("format", ffmpeg.Args{"gray"}).
+		Filter("negate", ffmpeg.Args{}).
+		Output(dir+"/%d.png", ffmpeg.KwArgs{"vsync": "vfr", "frame_pts": 1}).
+		OverWriteOutput()
+
+	log.Printf("Rendering subtitle s:%v for OCR.", sub.Source)
+	err = runFFmpeg(ctx, out, sub.File, duration, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := renderedFrames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	model := tesseractModel(sub.Language, ocr)
+	log.Printf("Recognizing %v subtitle frames with tesseract model %v.", len(frames), model)
+
+	var cues []cue
+	dropped := 0
+	for i, pts := range frames {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		text, confidence, err := recognize(ctx, ocr.Tesseract, fmt.Sprintf("%v/%v.png", dir, pts), model)
+		if err != nil {
+			return nil, err
+		}
+		if text == "" {
+			continue
+		}
+		if confidence < ocr.MinConfidence {
+			dropped++
+			continue
+		}
+
+		start := frameTime(pts)
+		end := time.Duration(duration * float64(time.Second))
+		if i+1 < len(frames) {
+			end = frameTime(frames[i+1])
+		}
+
+		// A subtitle that fades or moves shows up in several frames with the same text.
+		if n := len(cues); n > 0 && cues[n-1].Text == text && cues[n-1].End == start {
+			cues[n-1].End = end
+			continue
+		}
+		cues = append(cues, cue{Start: start, End: end, Text: text})
+	}
+
+	if dropped > 0 {
+		log.Printf("Dropped %v subtitles recognized with less than %v%% confidence.", dropped, ocr.MinConfidence)
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no text recognized in subtitle s:%v", sub.Source)
+	}
+
+	return cues, nil
+}
+
+// Timestamps of the frames rendered into dir, in order.
+func renderedFrames(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []int
+	for _, e := range entries {
+		pts, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".png"))
+		if err == nil {
+			frames = append(frames, pts)
+		}
+	}
+	sort.Ints(frames)
+
+	return frames, nil
+}
+
+func frameTime(pts int) time.Duration {
+	return time.Duration(pts) * time.Second / ocrFrameRate
+}
+
+// Tesseract model for a language: the one set in the config, otherwise the one named after the language.
+func tesseractModel(language string, ocr types.OCR) string {
+	if model, ok := ocr.Models[language]; ok {
+		return model
+	}
+	if model, ok := tesseractModels[language]; ok {
+		return model
+	}
+	return iso6392Code(language)
+}
+
+// Recognize the text of an image with tesseract. confidence is the mean confidence of its words.
+func recognize(ctx context.Context, tesseract, image, model string) (text string, confidence float64, err error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tesseract, image, "stdout", "-l", model, "--psm", "6", "tsv")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return "", 0, fmt.Errorf("tesseract failed: %w: %v", err, lastLines(stderr.String(), 5))
+	}
+
+	// TSV columns: level page_num block_num par_num line_num word_num left top width height conf text.
+	// Words are level 5 and their line is identified by block, paragraph and line numbers.
+	var lines []string
+	var lastLine string
+	var total float64
+	words := 0
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 || fields[0] != "5" {
+			continue
+		}
+		word := strings.TrimSpace(fields[11])
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if word == "" || err != nil || conf < 0 {
+			continue
+		}
+
+		line := strings.Join(fields[2:5], ".")
+		if line != lastLine || len(lines) == 0 {
+			lines = append(lines, word)
+			lastLine = line
+		} else {
+			lines[len(lines)-1] += " " + word
+		}
+		total += conf
+		words++
+	}
+
+	if words == 0 {
+		return "", 0, nil
+	}
+	return strings.Join(lines, "\n"), total / float64(words), nil
+}
+
+// Write the recognized subtitles in the format of sub.
+func writeCues(sub PlanSubtitle, cues []cue) error {
+	var b strings.Builder
+
+	switch sub.Format {
+	case "srt":
+		for i, c := range cues {
+			fmt.Fprintf(&b, "%v\n%v --> %v\n%v\n\n", i+1, cueTime(c.Start, ","), cueTime(c.End, ","), c.Text)
+		}
+	case "vtt":
+		b.WriteString("WEBVTT\n\n")
+		for _, c := range cues {
+			fmt.Fprintf(&b, "%v --> %v\n%v\n\n", cueTime(c.Start, "."), cueTime(c.End, "."), c.Text)
+		}
+	default:
+		return fmt.Errorf("unsupported OCR subtitle format: %v", sub.Format)
+	}
+
+	return os.WriteFile(sub.File, []byte(b.String()), 0666)
+}
+
+// Timestamp of a cue, e.g. 01:02:03,456 for srt.
+func cueTime(d time.Duration, separator string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
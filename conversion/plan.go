@@ -0,0 +1,385 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Processes a conversion plan can have.
+const (
+	processNone            = "none"            // File meets the profile. Only subtitles are extracted.
+	processSkip            = "skip"            // File can't be converted with the profile.
+	processDisposition     = "disposition"     // A compatible audio stream exists and becomes the default one.
+	processChannelToStereo = "channelToStereo" // An audio stream with the profile codec is downmixed into a new default stream.
+	processEncode          = "encode"          // An audio stream is encoded into a new default stream.
+)
+
+// What convert does with a file and why.
+type ConversionPlan struct {
+	File           string         `json:"file"`
+	Output         string         `json:"output,omitempty"`
+	Process        string         `json:"process"`
+	Duration       float64        `json:"duration"` // Seconds.
+	TranscodeVideo bool           `json:"transcodeVideo"`
+	ToneMap        bool           `json:"toneMap"`
+	ScaleWidth     int            `json:"scaleWidth,omitempty"` // Width the transcoded video is scaled down to.
+	Streams        []PlanStream   `json:"streams"`              // Streams of the output file, in order.
+	Subtitles      []PlanSubtitle `json:"subtitles"`            // Subtitles extracted next to the output file.
+	Reasons        []string       `json:"reasons"`
+	Devices        []DeviceReport `json:"devices,omitempty"` // Devices of the profile that can direct-play the source file.
+}
+
+// Stream of the output file.
+type PlanStream struct {
+	Type     string `json:"type"`               // video or audio.
+	Source   int    `json:"source"`             // Index of the source stream among the streams of its type, e.g. 1 for a:1.
+	Codec    string `json:"codec"`              // copy or the encoder.
+	Channels int    `json:"channels,omitempty"` // Channels of an encoded audio stream.
+	Downmix  string `json:"downmix,omitempty"`  // Downmix method of an encoded audio stream.
+	Filter   string `json:"filter,omitempty"`   // Audio filter of an encoded audio stream.
+	Language string `json:"language,omitempty"` // Language tag of an audio stream.
+	Title    string `json:"title,omitempty"`    // Title of an audio stream.
+	Default  bool   `json:"default"`
+	Reason   string `json:"reason"`
+}
+
+// Subtitle stream extracted into its own file.
+type PlanSubtitle struct {
+	Source   int    `json:"source"` // Index of the source stream among the subtitle streams, e.g. 1 for s:1.
+	Language string `json:"language"`
+	Tag      string `json:"tag,omitempty"` // Naming tag, e.g. ".forced".
+	Format   string `json:"format"`
+	File     string `json:"file"`
+	OCR      bool   `json:"ocr,omitempty"`   // Bitmap subtitle converted to text with tesseract.
+	Width    int    `json:"width,omitempty"` // Width and height the bitmaps are rendered at for OCR.
+	Height   int    `json:"height,omitempty"`
+}
+
+func (p *ConversionPlan) reason(format string, a ...interface{}) {
+	p.Reasons = append(p.Reasons, fmt.Sprintf(format, a...))
+}
+
+// Work out the conversion of a file from its ffprobe JSON output. It doesn't touch the file.
+func Plan(probeData string, filePath string, profile types.ConversionProfile) (ConversionPlan, error) {
+	plan := ConversionPlan{File: filePath}
+
+	var (
+		audio                = []audioStream{}
+		videoStreams         int
+		mainVideoStreamIndex = -1 // First video stream that isn't a cover image.
+		mainVideo            *ProbeStream
+		videoWidth           = 1920
+		videoHeight          = 1080
+	)
+
+	var vFileInfo VideoFileInfoProbe
+	err := json.Unmarshal([]byte(probeData), &vFileInfo)
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Duration, _ = strconv.ParseFloat(vFileInfo.Format.Duration, 64)
+
+	subStreamIndex := 0
+	for _, s := range vFileInfo.Streams {
+
+		if s.CodecType == "video" {
+			videoStreams++
+		}
+
+		// Check that the video codec can be handled by the profile. Cover images are ignored.
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 && mainVideoStreamIndex == -1 {
+			mainVideoStreamIndex = videoStreams - 1
+			if s.Width > 0 && s.Height > 0 {
+				videoWidth, videoHeight = s.Width, s.Height
+			}
+			video := s
+			mainVideo = &video
+
+			// Without devices, only 8-bit SDR h264 is compatible.
+			compatible := s.CodecName == "h264" && !is10Bit(s.PixFmt) && !isHDR(s.ColorTransfer)
+			if len(profile.DeviceProfiles) > 0 {
+				compatible = allDevices(profile.DeviceProfiles, func(d types.DeviceProfile) bool { return len(videoIssues(s, d)) == 0 })
+			}
+
+			if profile.TranscodeVideo {
+				if !compatible {
+					plan.TranscodeVideo = true
+					plan.ToneMap = isHDR(s.ColorTransfer)
+					if w := maxWidth(profile.DeviceProfiles); w > 0 && s.Width > w {
+						plan.ScaleWidth = w
+					}
+					plan.reason("video stream %v (%v, %v) is transcoded to 8-bit h264, tone mapping: %v", s.Index, s.CodecName, s.PixFmt, plan.ToneMap)
+				}
+			} else if containsFold(profile.SkipVideoCodecs, s.CodecName) {
+				plan.Process = processSkip
+				plan.Subtitles = nil
+				plan.reason("%v video is skipped by the profile", s.CodecName)
+				return plan, nil
+			} else if !compatible && len(profile.DeviceProfiles) > 0 {
+				plan.reason("video stream %v (%v, %v) can't be played by every device but the profile doesn't transcode video", s.Index, s.CodecName, s.PixFmt)
+			}
+		}
+
+		// Extract subs
+		if s.CodecType == "subtitle" {
+			if language, ok := matchLanguage(profile.Languages, s.Tags.Language, s.Tags.Title); ok {
+				if bitmapSubtitleCodecs[s.CodecName] && !profile.OCR.Enabled {
+					plan.reason("subtitle s:%v (%v %q) is %v, a bitmap format that can't be converted to text", subStreamIndex, s.Tags.Language, s.Tags.Title, s.CodecName)
+				} else {
+					code := languageCode(language)
+					tag := subtitleTag(s.Disposition.Default == 1, isForced(s.Disposition.Forced, s.Tags.Title), isHearingImpaired(s.Disposition.HearingImpaired, s.Tags.HandlerName, s.Tags.Title), profile.HearingImpairedTag)
+					added := false
+					for _, format := range profile.SubtitleFormats {
+						format = subtitleFormat(format)
+						file := subtitleFile(filePath, code, tag, format)
+						if subtitlePlanned(plan.Subtitles, file) {
+							continue
+						}
+						sub := PlanSubtitle{
+							Source:   subStreamIndex,
+							Language: code,
+							Tag:      tag,
+							Format:   format,
+							File:     file,
+						}
+						if bitmapSubtitleCodecs[s.CodecName] {
+							sub.OCR = true
+							sub.Width, sub.Height = s.Width, s.Height
+							if sub.Width == 0 || sub.Height == 0 {
+								sub.Width, sub.Height = videoWidth, videoHeight
+							}
+						}
+						plan.Subtitles = append(plan.Subtitles, sub)
+						added = true
+					}
+					if added && bitmapSubtitleCodecs[s.CodecName] {
+						plan.reason("subtitle s:%v (%v %q) matches profile language %v and is converted from %v with OCR", subStreamIndex, s.Tags.Language, s.Tags.Title, language, s.CodecName)
+					} else if added {
+						plan.reason("subtitle s:%v (%v %q) matches profile language %v", subStreamIndex, s.Tags.Language, s.Tags.Title, language)
+					} else {
+						plan.reason("subtitle s:%v (%v %q) is skipped, another %v%v subtitle is extracted", subStreamIndex, s.Tags.Language, s.Tags.Title, code, tag)
+					}
+				}
+			}
+
+			subStreamIndex++
+		}
+
+		if s.CodecType != "audio" {
+			continue
+		}
+
+		audio = append(audio, audioStream{
+			Codec:      s.CodecName,
+			Channels:   s.Channels,
+			Layout:     s.ChannelLayout,
+			Language:   languageCode(s.Tags.Language),
+			Title:      s.Tags.Title,
+			Default:    s.Disposition.Default == 1,
+			Commentary: s.Disposition.Comment == 1 || strings.Contains(strings.ToLower(s.Tags.Title), "commentary"),
+		})
+	}
+
+	if len(audio) == 0 {
+		plan.Process = processNone
+		plan.reason("no audio streams found")
+		return plan, nil
+	}
+
+	// Tracks kept in the output and the ones in the preferred language, the source of the compatible track.
+	kept, preferred := selectAudio(&plan, audio, profile)
+
+	// With devices, the created track and the container must be played by every device too.
+	audioCodec, audioChannels, err := deviceAudio(profile.DeviceProfiles, profile.AudioCodec, profile.AudioChannels)
+	if err != nil {
+		return plan, err
+	}
+	if audioCodec != profile.AudioCodec || audioChannels != profile.AudioChannels {
+		plan.reason("%v %v channels can't be played by every device, %v %v channels is used", profile.AudioCodec, profile.AudioChannels, audioCodec, audioChannels)
+	}
+	outputContainer, err := deviceContainer(profile.DeviceProfiles, container(filePath), profile.Container)
+	if err != nil {
+		return plan, err
+	}
+	switch {
+	case outputContainer == profile.Container:
+	case outputContainer == container(filePath):
+		plan.reason("every device plays the %v container of the source, it is kept", outputContainer)
+	default:
+		plan.reason("container %v can't be played by every device, %v is used", profile.Container, outputContainer)
+	}
+
+	// Check if there is a preferred audio stream that already meets the profile, otherwise one with the profile codec.
+	var (
+		audioStreamIndex        = -1 // Audio stream with the profile codec and channels.
+		audioCodecStreamIndex   = -1 // Audio stream with the profile codec but other channels.
+		audioDefaultStreamIndex = 0  // Default audio stream of the file.
+		preferredDefaultIndex   = preferred[0]
+	)
+	for i := len(audio) - 1; i >= 0; i-- {
+		if audio[i].Default {
+			audioDefaultStreamIndex = i
+		}
+	}
+	for i := len(preferred) - 1; i >= 0; i-- {
+		a := audio[preferred[i]]
+		if a.Default {
+			preferredDefaultIndex = preferred[i]
+		}
+
+		// With devices, a stream is compatible if every device plays it.
+		fits := a.Codec == audioCodec && a.Channels == audioChannels
+		codecFits := a.Codec == audioCodec
+		if len(profile.DeviceProfiles) > 0 {
+			fits = allDevices(profile.DeviceProfiles, func(d types.DeviceProfile) bool { return audioFits(a, d) })
+			codecFits = allDevices(profile.DeviceProfiles, func(d types.DeviceProfile) bool { return containsFold(d.AudioCodecs, a.Codec) })
+		}
+
+		if fits {
+			audioStreamIndex = preferred[i]
+		} else if codecFits {
+			audioCodecStreamIndex = preferred[i]
+		}
+	}
+
+	plan.Devices = checkDevices(filePath, mainVideo, &audio[audioDefaultStreamIndex], profile.DeviceProfiles, profile.SubtitleFormats)
+
+	sameContainer := container(filePath) == outputContainer
+	if len(profile.DeviceProfiles) > 0 {
+		sameContainer = allDevices(profile.DeviceProfiles, func(d types.DeviceProfile) bool { return containerFits(filePath, d) })
+	}
+	onlyCompatible := (profile.KeepOriginalTracks && len(kept) == len(audio)) || len(audio) == 1
+
+	switch {
+	case audioStreamIndex != -1 && audioStreamIndex == audioDefaultStreamIndex && sameContainer && onlyCompatible && !plan.TranscodeVideo:
+		plan.Process = processNone
+		if len(profile.DeviceProfiles) > 0 {
+			plan.reason("every device can direct-play the file")
+		} else {
+			plan.reason("default audio a:%v is already %v %v channels in %v", audioStreamIndex, audioCodec, audioChannels, outputContainer)
+		}
+		return plan, nil
+	case audioStreamIndex != -1:
+		plan.Process = processDisposition
+		if audioStreamIndex != audioDefaultStreamIndex {
+			plan.reason("a:%v is %v %v channels but a:%v is the default one", audioStreamIndex, audioCodec, audioChannels, audioDefaultStreamIndex)
+		} else {
+			plan.reason("default audio a:%v is compatible but the file is remuxed (container %v, keep original tracks: %v, transcode video: %v)", audioStreamIndex, outputContainer, profile.KeepOriginalTracks, plan.TranscodeVideo)
+		}
+	case audioCodecStreamIndex != -1:
+		plan.Process = processChannelToStereo
+		audioStreamIndex = audioCodecStreamIndex
+		plan.reason("no %v channels stream, a:%v is %v and is downmixed", audioChannels, audioStreamIndex, audioCodec)
+	default:
+		plan.Process = processEncode
+		audioStreamIndex = preferredDefaultIndex
+		plan.reason("no usable %v stream, audio a:%v is encoded", audioCodec, audioStreamIndex)
+	}
+
+	plan.Output = outputFile(filePath, outputContainer)
+	if mainVideoStreamIndex == -1 {
+		mainVideoStreamIndex = 0
+	}
+	plan.Streams = append(plan.Streams, PlanStream{Type: "video", Source: mainVideoStreamIndex, Codec: "copy", Reason: "main video"})
+	if plan.TranscodeVideo {
+		plan.Streams[0].Codec = "libx264"
+		plan.Streams[0].Reason = "transcoded to 8-bit h264"
+	}
+
+	if plan.Process == processDisposition {
+		plan.Streams = append(plan.Streams, PlanStream{Type: "audio", Source: audioStreamIndex, Codec: "copy", Default: true, Language: audio[audioStreamIndex].Language, Title: audio[audioStreamIndex].Title, Reason: "already compatible"})
+	} else {
+		stream := PlanStream{Type: "audio", Source: audioStreamIndex, Codec: audioCodec, Channels: audioChannels, Default: true, Language: audio[audioStreamIndex].Language, Title: audioTitle(audioCodec, audioChannels), Reason: "compatible stream"}
+		var reason string
+		stream.Downmix, stream.Filter, reason = downmix(profile.Downmix, audio[audioStreamIndex].Channels, audio[audioStreamIndex].Layout, audioChannels)
+		if reason != "" {
+			plan.reason("a:%v %v downmix: %v", audioStreamIndex, stream.Downmix, reason)
+		}
+		plan.Streams = append(plan.Streams, stream)
+	}
+
+	if profile.KeepOriginalTracks {
+		for _, i := range kept {
+			// A stream copied as the default one isn't needed twice.
+			if i == audioStreamIndex && plan.Process == processDisposition {
+				continue
+			}
+			plan.Streams = append(plan.Streams, PlanStream{Type: "audio", Source: i, Codec: "copy", Language: audio[i].Language, Title: audio[i].Title, Reason: "original track"})
+		}
+	}
+
+	return plan, nil
+}
+
+// Subtitle codecs stored as images. ffmpeg can't convert them to text formats.
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// Naming tag of a subtitle as media servers read it, e.g. ".default.forced" or ".sdh".
+func subtitleTag(isDefault, forced, hearingImpaired bool, hearingImpairedTag string) string {
+	tag := ""
+	if isDefault {
+		tag += ".default"
+	}
+	if forced {
+		tag += ".forced"
+	}
+	if hearingImpaired {
+		tag += "." + hearingImpairedTag
+	}
+	return tag
+}
+
+// Forced subtitles are flagged by the disposition, some releases only say it in the title.
+func isForced(disposition int, title string) bool {
+	return disposition == 1 || strings.Contains(strings.ToLower(title), "forced")
+}
+
+// Hearing impaired subtitles are flagged by the disposition, some releases only say it in the handler name or title.
+func isHearingImpaired(disposition int, handlerName, title string) bool {
+	if disposition == 1 {
+		return true
+	}
+	for _, s := range []string{handlerName, title} {
+		s = strings.ToLower(s)
+		if strings.Contains(s, "hearing impaired") || strings.Contains(s, "sdh") || s == "cc" {
+			return true
+		}
+	}
+	return false
+}
+
+// File extension of a subtitle format. webvtt is accepted for vtt.
+func subtitleFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == "webvtt" {
+		return "vtt"
+	}
+	return format
+}
+
+func subtitlePlanned(subtitles []PlanSubtitle, file string) bool {
+	for _, s := range subtitles {
+		if s.File == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Path of a subtitle extracted next to the video file.
+func subtitleFile(filePath, language, customNamingTag, format string) string {
+	fileName := filepath.Base(filePath)
+	fileDir := filepath.Dir(filePath)
+
+	return fmt.Sprintf("%v/%v.%v%v.%v", fileDir, strings.TrimSuffix(fileName, filepath.Ext(fileName)), language, customNamingTag, format)
+}
@@ -0,0 +1,296 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// ffprobe output of a file in testdata. The files are synthetic, written in the shape of
+// ffprobe -print_format json -show_format -show_streams.
+func probeFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Profile as conversion.Profile resolves it with the defaults.
+func testProfile() types.ConversionProfile {
+	return types.ConversionProfile{
+		Container:          "mp4",
+		AudioCodec:         "aac",
+		AudioChannels:      2,
+		Downmix:            downmixStandard,
+		Languages:          []string{"en"},
+		SubtitleFormats:    []string{"vtt"},
+		HearingImpairedTag: "sdh",
+		VideoCRF:           20,
+		VideoPreset:        "medium",
+		ToneMapping:        "hable",
+		OCR:                types.OCR{Tesseract: "tesseract", MinConfidence: 60},
+	}
+}
+
+// Streams of a plan in short form, e.g. "a:1 aac 2 default".
+func planStreams(plan ConversionPlan) []string {
+	var streams []string
+	for _, s := range plan.Streams {
+		stream := fmt.Sprintf("%v:%v %v", s.Type[:1], s.Source, s.Codec)
+		if s.Channels > 0 {
+			stream += fmt.Sprintf(" %v", s.Channels)
+		}
+		if s.Default {
+			stream += " default"
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+func planSubtitleFiles(plan ConversionPlan) []string {
+	var files []string
+	for _, s := range plan.Subtitles {
+		files = append(files, s.File)
+	}
+	return files
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		file      string
+		profile   func(p *types.ConversionProfile)
+		process   string
+		output    string
+		streams   []string
+		subtitles []string
+	}{
+		{
+			name:      "compatible file",
+			fixture:   "h264_aac_stereo",
+			file:      "/dl/Movie.mp4",
+			process:   processNone,
+			subtitles: []string{"/dl/Movie.en.vtt"},
+		},
+		{
+			name:    "compatible stream isn't the default one",
+			fixture: "h264_ac3_aac",
+			file:    "/dl/Movie.mkv",
+			process: processDisposition,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:1 copy default"},
+		},
+		{
+			name:    "compatible stream with the original tracks",
+			fixture: "h264_ac3_aac",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) { p.KeepOriginalTracks = true },
+			process: processDisposition,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:1 copy default", "a:0 copy"},
+		},
+		{
+			name:    "profile codec with more channels",
+			fixture: "h264_aac_51",
+			file:    "/dl/Movie.mkv",
+			process: processChannelToStereo,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:0 aac 2 default"},
+		},
+		{
+			name:      "other codec is encoded",
+			fixture:   "h264_dts_subs",
+			file:      "/dl/Movie.mkv",
+			process:   processEncode,
+			output:    "/dl/Movie.mp4",
+			streams:   []string{"v:0 copy", "a:0 aac 2 default"},
+			subtitles: []string{"/dl/Movie.en.default.vtt", "/dl/Movie.en.sdh.vtt", "/dl/Movie.en.forced.vtt"},
+		},
+		{
+			name:    "no audio",
+			fixture: "no_audio",
+			file:    "/dl/Movie.mkv",
+			process: processNone,
+		},
+		{
+			name:    "cover art isn't the main video",
+			fixture: "cover_art",
+			file:    "/dl/Movie.mkv",
+			process: processDisposition,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:1 copy", "a:0 copy default"},
+		},
+		{
+			name:    "bitmap subtitles without OCR",
+			fixture: "h264_dts_subs",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) { p.Languages = []string{"es"} },
+			process: processEncode,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:0 aac 2 default"},
+		},
+		{
+			name:    "bitmap subtitles with OCR",
+			fixture: "h264_dts_subs",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) {
+				p.Languages = []string{"es"}
+				p.SubtitleFormats = []string{"srt", "vtt"}
+				p.OCR.Enabled = true
+			},
+			process:   processEncode,
+			output:    "/dl/Movie.mp4",
+			streams:   []string{"v:0 copy", "a:0 aac 2 default"},
+			subtitles: []string{"/dl/Movie.es.srt", "/dl/Movie.es.vtt"},
+		},
+		{
+			name:    "skipped video codec",
+			fixture: "hevc_hdr_4k",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) { p.SkipVideoCodecs = []string{"hevc"} },
+			process: processSkip,
+		},
+		{
+			name:    "HDR video is transcoded",
+			fixture: "hevc_hdr_4k",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) { p.TranscodeVideo = true },
+			process: processDisposition,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 libx264", "a:0 copy default"},
+		},
+		{
+			name:    "audio of the original language",
+			fixture: "multi_language",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) {
+				p.AudioLanguages = []string{"original", "en"}
+				p.DropCommentary = true
+				p.KeepOriginalTracks = true
+			},
+			process: processEncode,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:0 aac 2 default", "a:0 copy", "a:1 copy"},
+		},
+		{
+			name:    "audio of a preferred language",
+			fixture: "multi_language",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) {
+				p.AudioLanguages = []string{"en"}
+				p.DropCommentary = true
+			},
+			process: processEncode,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:1 aac 2 default"},
+		},
+		{
+			name:    "commentary in the preferred language is kept but isn't the default one",
+			fixture: "multi_language",
+			file:    "/dl/Movie.mkv",
+			profile: func(p *types.ConversionProfile) {
+				p.AudioLanguages = []string{"en"}
+				p.KeepOriginalTracks = true
+			},
+			process: processEncode,
+			output:  "/dl/Movie.mp4",
+			streams: []string{"v:0 copy", "a:1 aac 2 default", "a:1 copy", "a:2 copy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := testProfile()
+			if tt.profile != nil {
+				tt.profile(&profile)
+			}
+
+			plan, err := Plan(probeFixture(t, tt.fixture), tt.file, profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plan.Process != tt.process {
+				t.Errorf("process = %v, want %v. Reasons: %q", plan.Process, tt.process, plan.Reasons)
+			}
+			if plan.Output != tt.output {
+				t.Errorf("output = %v, want %v", plan.Output, tt.output)
+			}
+			if got := planStreams(plan); !reflect.DeepEqual(got, tt.streams) {
+				t.Errorf("streams = %q, want %q", got, tt.streams)
+			}
+			if got := planSubtitleFiles(plan); !reflect.DeepEqual(got, tt.subtitles) {
+				t.Errorf("subtitles = %q, want %q", got, tt.subtitles)
+			}
+		})
+	}
+}
+
+func TestPlanHDRTranscode(t *testing.T) {
+	profile := testProfile()
+	profile.TranscodeVideo = true
+
+	plan, err := Plan(probeFixture(t, "hevc_hdr_4k"), "/dl/Movie.mkv", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.TranscodeVideo || !plan.ToneMap {
+		t.Errorf("transcode video = %v, tone map = %v, want both", plan.TranscodeVideo, plan.ToneMap)
+	}
+	if plan.ScaleWidth != 0 {
+		t.Errorf("scale width = %v, want 0 without devices", plan.ScaleWidth)
+	}
+}
+
+func TestPlanOCRSubtitleSize(t *testing.T) {
+	profile := testProfile()
+	profile.Languages = []string{"es"}
+	profile.OCR.Enabled = true
+
+	plan, err := Plan(probeFixture(t, "h264_dts_subs"), "/dl/Movie.mkv", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Subtitles) != 1 {
+		t.Fatalf("subtitles = %v, want 1", len(plan.Subtitles))
+	}
+	sub := plan.Subtitles[0]
+	if !sub.OCR || sub.Source != 3 || sub.Width != 1920 || sub.Height != 1080 {
+		t.Errorf("subtitle = %+v, want s:3 OCR at 1920x1080", sub)
+	}
+}
+
+func TestPlanDevices(t *testing.T) {
+	profile := testProfile()
+	profile.TranscodeVideo = true
+	profile.Container = "mkv"
+	var err error
+	profile.DeviceProfiles, err = DeviceProfiles(types.TomlConfig{}, []string{"chromecast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := Plan(probeFixture(t, "hevc_hdr_4k"), "/dl/Movie.mkv", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chromecast plays neither mkv, HEVC nor 4K.
+	if plan.Output != "/dl/Movie.mp4" {
+		t.Errorf("output = %v, want /dl/Movie.mp4", plan.Output)
+	}
+	if !plan.TranscodeVideo || plan.ScaleWidth != 1920 {
+		t.Errorf("transcode video = %v, scale width = %v, want transcoded to 1920", plan.TranscodeVideo, plan.ScaleWidth)
+	}
+	if len(plan.Devices) != 1 || plan.Devices[0].DirectPlay {
+		t.Errorf("devices = %+v, want chromecast not direct-playing the source", plan.Devices)
+	}
+}
@@ -0,0 +1,251 @@
+package conversion
+
+import (
+	"debridGo/types"
+	"errors"
+	"strings"
+)
+
+// Profile used when the config has none: AAC 2.0 default audio in an MP4, English and Latin-American Spanish subs, skip HEVC.
+var defaultProfile = types.ConversionProfile{
+	Container:          "mp4",
+	AudioCodec:         "aac",
+	AudioChannels:      2,
+	Downmix:            downmixStandard,
+	Languages:          []string{"en", "es-419"},
+	SubtitleFormats:    []string{"vtt"},
+	HearingImpairedTag: "sdh",
+	KeepOriginalTracks: true,
+	SkipVideoCodecs:    []string{"hevc", "h265"},
+}
+
+// Get the conversion profile for a job. The profile set for the release quality is used first, then the one for the
+// category and then the default profile of the config. Without any profile in the config the built-in one is used.
+func Profile(conf types.TomlConfig, category, quality string) (types.ConversionProfile, error) {
+	name := conf.Conversion.DefaultProfile
+	if p, ok := conf.Conversion.Categories[category]; ok {
+		name = p
+	}
+	if p, ok := conf.Conversion.Qualities[quality]; ok {
+		name = p
+	}
+
+	if name == "" {
+		return defaultProfile, nil
+	}
+
+	var err error
+	profile, ok := conf.Conversion.Profiles[name]
+	if !ok {
+		return profile, errors.New("conversion profile not found: " + name)
+	}
+
+	// Fill what the profile doesn't set with the built-in values.
+	if profile.Container == "" {
+		profile.Container = defaultProfile.Container
+	}
+	if profile.AudioCodec == "" {
+		profile.AudioCodec = defaultProfile.AudioCodec
+	}
+	if profile.AudioChannels == 0 {
+		profile.AudioChannels = defaultProfile.AudioChannels
+	}
+	if profile.Downmix == "" {
+		profile.Downmix = downmixStandard
+	}
+	if len(profile.SubtitleFormats) == 0 {
+		profile.SubtitleFormats = defaultProfile.SubtitleFormats
+	}
+	if profile.HearingImpairedTag == "" {
+		profile.HearingImpairedTag = defaultProfile.HearingImpairedTag
+	}
+	if profile.VideoCRF == 0 {
+		profile.VideoCRF = 20
+	}
+	if profile.VideoPreset == "" {
+		profile.VideoPreset = "medium"
+	}
+	if profile.ToneMapping == "" {
+		profile.ToneMapping = "hable"
+	}
+	profile.DeviceProfiles, err = DeviceProfiles(conf, profile.Devices)
+	if err != nil {
+		return profile, err
+	}
+
+	if profile.OCR.Tesseract == "" {
+		profile.OCR.Tesseract = "tesseract"
+	}
+	if profile.OCR.MinConfidence == 0 {
+		profile.OCR.MinConfidence = 60
+	}
+
+	return profile, nil
+}
+
+// ISO 639-2 codes of common languages, bibliographic and terminologic, and their ISO 639-1 equivalent.
+var iso6392 = map[string]string{
+	"ara": "ar",
+	"baq": "eu",
+	"bul": "bg",
+	"cat": "ca",
+	"ces": "cs",
+	"chi": "zh",
+	"cze": "cs",
+	"dan": "da",
+	"deu": "de",
+	"dut": "nl",
+	"ell": "el",
+	"eng": "en",
+	"est": "et",
+	"eus": "eu",
+	"fas": "fa",
+	"fil": "tl",
+	"fin": "fi",
+	"fra": "fr",
+	"fre": "fr",
+	"ger": "de",
+	"glg": "gl",
+	"gre": "el",
+	"heb": "he",
+	"hin": "hi",
+	"hrv": "hr",
+	"hun": "hu",
+	"ice": "is",
+	"ind": "id",
+	"isl": "is",
+	"ita": "it",
+	"jpn": "ja",
+	"kor": "ko",
+	"lav": "lv",
+	"lit": "lt",
+	"may": "ms",
+	"msa": "ms",
+	"nld": "nl",
+	"nob": "nb",
+	"nor": "no",
+	"per": "fa",
+	"pol": "pl",
+	"por": "pt",
+	"ron": "ro",
+	"rum": "ro",
+	"rus": "ru",
+	"slk": "sk",
+	"slo": "sk",
+	"slv": "sl",
+	"spa": "es",
+	"srp": "sr",
+	"swe": "sv",
+	"tgl": "tl",
+	"tha": "th",
+	"tur": "tr",
+	"ukr": "uk",
+	"vie": "vi",
+	"zho": "zh",
+}
+
+// ISO 639-2 terminologic code of each two letter code of iso6392. A fixed table, as some two letter codes have several
+// ISO 639-2 codes, e.g. "tl" for tgl and fil.
+var iso6392Codes = map[string]string{
+	"ar": "ara",
+	"bg": "bul",
+	"ca": "cat",
+	"cs": "ces",
+	"da": "dan",
+	"de": "deu",
+	"el": "ell",
+	"en": "eng",
+	"es": "spa",
+	"et": "est",
+	"eu": "eus",
+	"fa": "fas",
+	"fi": "fin",
+	"fr": "fra",
+	"gl": "glg",
+	"he": "heb",
+	"hi": "hin",
+	"hr": "hrv",
+	"hu": "hun",
+	"id": "ind",
+	"is": "isl",
+	"it": "ita",
+	"ja": "jpn",
+	"ko": "kor",
+	"lt": "lit",
+	"lv": "lav",
+	"ms": "msa",
+	"nb": "nob",
+	"nl": "nld",
+	"no": "nor",
+	"pl": "pol",
+	"pt": "por",
+	"ro": "ron",
+	"ru": "rus",
+	"sk": "slk",
+	"sl": "slv",
+	"sr": "srp",
+	"sv": "swe",
+	"th": "tha",
+	"tl": "tgl",
+	"tr": "tur",
+	"uk": "ukr",
+	"vi": "vie",
+	"zh": "zho",
+}
+
+// Names used in track titles for the regions of a language.
+var regionNames = map[string][]string{
+	"419": {"Latin America", "Latinoamérica", "Latino", "LatAm"},
+	"ES":  {"Spain", "España", "Castilian", "Castellano"},
+	"BR":  {"Brazil", "Brasil"},
+	"PT":  {"Portugal"},
+}
+
+// Two letter code of a language tag, e.g. "eng" -> "en". Region subtags are dropped: "en-US" -> "en".
+func languageCode(tag string) string {
+	tag, _, _ = strings.Cut(strings.ToLower(strings.ReplaceAll(tag, "_", "-")), "-")
+	if code, ok := iso6392[tag]; ok {
+		return code
+	}
+	return tag
+}
+
+// ISO 639-2 terminologic code of a two letter code, e.g. "en" -> "eng". "und" if unknown.
+func iso6392Code(code string) string {
+	if c, ok := iso6392Codes[code]; ok {
+		return c
+	}
+	if len(code) == 3 {
+		return code
+	}
+	return "und"
+}
+
+// Get the profile language, e.g. "en" or "es-419", that a track with the language tag and title matches.
+func matchLanguage(languages []string, tag, title string) (string, bool) {
+	code := languageCode(tag)
+	for _, l := range languages {
+		lang, region, _ := strings.Cut(l, "-")
+		if languageCode(lang) != code {
+			continue
+		}
+		if region == "" {
+			return l, true
+		}
+		for _, name := range regionNames[strings.ToUpper(region)] {
+			if strings.Contains(strings.ToLower(title), strings.ToLower(name)) {
+				return l, true
+			}
+		}
+	}
+	return "", false
+}
+
+func containsFold(s []string, v string) bool {
+	for _, i := range s {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package conversion
+
+import "testing"
+
+func TestISO6392Code(t *testing.T) {
+	tests := map[string]string{"en": "eng", "fr": "fra", "tl": "tgl", "no": "nor", "pt-BR": "und", "xx": "und", "gsw": "gsw"}
+	for code, want := range tests {
+		if got := iso6392Code(code); got != want {
+			t.Errorf("iso6392Code(%v) = %v, want %v", code, got, want)
+		}
+	}
+
+	// Every language known by its ISO 639-2 code can be written back.
+	for c, code := range iso6392 {
+		if got := iso6392Code(code); iso6392[got] != code {
+			t.Errorf("iso6392Code(%v) = %v, which isn't %v like %v", code, got, code, c)
+		}
+	}
+}
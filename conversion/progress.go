@@ -0,0 +1,66 @@
+package conversion
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress of an ffmpeg conversion.
+type Progress struct {
+	File    string
+	Percent float64       // 0 to 100. Stays at 0 when the duration of the source is unknown.
+	Speed   float64       // Encoding speed relative to playback, e.g. 2.5 for 2.5x.
+	ETA     time.Duration // Time left. 0 when unknown.
+	Done    bool
+}
+
+// Receives the progress of a conversion every time ffmpeg reports it.
+type ProgressFunc func(Progress)
+
+// Parse the key=value blocks ffmpeg writes with -progress and report each of them to progress. duration is the length
+// of the source in seconds.
+func readProgress(r io.Reader, file string, duration float64, progress ProgressFunc) {
+	p := Progress{File: file}
+	var outTime float64
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		// Despite the name, out_time_ms is in microseconds too. Newer ffmpeg versions write both.
+		case "out_time_us", "out_time_ms":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && us > 0 {
+				outTime = float64(us) / 1e6
+			}
+		case "speed":
+			speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+			if err == nil {
+				p.Speed = speed
+			}
+		case "progress":
+			p.Done = value == "end"
+			p.Percent, p.ETA = 0, 0
+			if duration > 0 {
+				p.Percent = outTime / duration * 100
+				if p.Percent > 100 || p.Done {
+					p.Percent = 100
+				}
+				if p.Speed > 0 && !p.Done && outTime < duration {
+					p.ETA = time.Duration((duration - outTime) / p.Speed * float64(time.Second))
+				}
+			}
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}
+}
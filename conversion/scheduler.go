@@ -0,0 +1,217 @@
+package conversion
+
+import (
+	"context"
+	"debridGo/lockfile"
+	"debridGo/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Interval between tries of a waiting conversion to get an ffmpeg slot.
+const slotPollInterval = 2 * time.Second
+
+// Conversions waiting for an ffmpeg slot, shared by every debridGo process and saved in the download directory.
+// Only the first waiter, by priority and then by arrival, may take a free slot.
+type slotQueue struct {
+	Next    int          `json:"next"` // Id of the next waiter.
+	Waiters []slotWaiter `json:"waiters"`
+}
+
+type slotWaiter struct {
+	Id       int       `json:"id"`
+	Pid      int       `json:"pid"`
+	Priority int       `json:"priority"` // Higher goes first.
+	Added    time.Time `json:"added"`
+	File     string    `json:"file"`
+}
+
+// ffmpeg slot held by a conversion. Slots are lock files, so a crashed process frees its slots.
+type Slot struct {
+	lock *lockfile.Lock
+}
+
+func (s *Slot) Release() error {
+	return s.lock.Release()
+}
+
+// Number of ffmpeg processes that can run at once. Defaults to one per 4 CPUs.
+func MaxProcesses(conf types.TomlConfig) int {
+	if conf.Ffmpeg.MaxProcesses > 0 {
+		return conf.Ffmpeg.MaxProcesses
+	}
+	if n := runtime.NumCPU() / 4; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// Block until one of the ffmpeg slots shared by every debridGo process is free and take it for the conversion of file.
+// category decides the priority of the conversion, see the Priority setting of the ffmpeg config.
+func AcquireSlot(ctx context.Context, conf types.TomlConfig, category, file string) (*Slot, error) {
+	queueFile := conf.DebridGo.DownloadDir + "/ffmpeg-queue.json"
+
+	var id int
+	err := updateSlotQueue(queueFile, func(q *slotQueue) error {
+		id = q.Next
+		q.Next++
+		q.Waiters = append(q.Waiters, slotWaiter{Id: id, Pid: os.Getpid(), Priority: priority(conf, category), Added: time.Now(), File: file})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logged := false
+	for {
+		var slot *Slot
+		err = updateSlotQueue(queueFile, func(q *slotQueue) error {
+			if !waiting(q, id) {
+				// The queue file was removed while waiting.
+				q.Waiters = append(q.Waiters, slotWaiter{Id: id, Pid: os.Getpid(), Priority: priority(conf, category), Added: time.Now(), File: file})
+				if q.Next <= id {
+					q.Next = id + 1
+				}
+			}
+			if q.Waiters[0].Id != id {
+				return nil
+			}
+
+			lock, err := freeSlot(conf)
+			if err != nil || lock == nil {
+				return err
+			}
+			slot = &Slot{lock: lock}
+			q.Waiters = q.Waiters[1:]
+			return nil
+		})
+		if err != nil || slot != nil {
+			return slot, err
+		}
+
+		if !logged {
+			log.Printf("Waiting for a free ffmpeg slot to convert %v.", file)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			leaveSlotQueue(queueFile, id)
+			return nil, ctx.Err()
+		case <-time.After(slotPollInterval):
+		}
+	}
+}
+
+// Lock the first free slot. nil if every slot is taken.
+func freeSlot(conf types.TomlConfig) (*lockfile.Lock, error) {
+	for i := 0; i < MaxProcesses(conf); i++ {
+		lock, ok, err := lockfile.TryAcquire(fmt.Sprintf("%v/ffmpeg-slot-%v.lock", conf.DebridGo.DownloadDir, i))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return lock, nil
+		}
+	}
+	return nil, nil
+}
+
+func waiting(q *slotQueue, id int) bool {
+	for _, w := range q.Waiters {
+		if w.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func leaveSlotQueue(queueFile string, id int) {
+	err := updateSlotQueue(queueFile, func(q *slotQueue) error {
+		for i, w := range q.Waiters {
+			if w.Id == id {
+				q.Waiters = append(q.Waiters[:i], q.Waiters[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Could not leave the ffmpeg queue: ", err)
+	}
+}
+
+func priority(conf types.TomlConfig, category string) int {
+	movie := category == "radarr"
+	if (conf.Ffmpeg.Priority == "episodes") == movie {
+		return 0
+	}
+	return 1
+}
+
+// Read, modify and write the slot queue while holding its lock. Waiters of dead processes are dropped and the rest
+// sorted, so the first waiter is the next to take a slot.
+func updateSlotQueue(queueFile string, update func(q *slotQueue) error) error {
+	lock, err := lockfile.Acquire(queueFile)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	f := lock.File()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var q slotQueue
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &q)
+		if err != nil {
+			return err
+		}
+	}
+
+	var alive []slotWaiter
+	for _, w := range q.Waiters {
+		if processAlive(w.Pid) {
+			alive = append(alive, w)
+		}
+	}
+	q.Waiters = alive
+	sort.SliceStable(q.Waiters, func(i, j int) bool {
+		if q.Waiters[i].Priority != q.Waiters[j].Priority {
+			return q.Waiters[i].Priority > q.Waiters[j].Priority
+		}
+		return q.Waiters[i].Id < q.Waiters[j].Id
+	})
+
+	err = update(&q)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, 0)
+	return err
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
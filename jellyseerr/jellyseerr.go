@@ -0,0 +1,156 @@
+package jellyseerr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Media request as returned by the jellyseerr/overseerr /request endpoint.
+type Request struct {
+	Id     int  `json:"id"`
+	Status int  `json:"status"`
+	Is4k   bool `json:"is4k"`
+	Media  struct {
+		Id        int    `json:"id"`
+		TmdbId    int    `json:"tmdbId"`
+		TvdbId    int    `json:"tvdbId"`
+		MediaType string `json:"mediaType"`
+		Status    int    `json:"status"`
+	} `json:"media"`
+	RequestedBy struct {
+		Id          int    `json:"id"`
+		DisplayName string `json:"displayName"`
+	} `json:"requestedBy"`
+}
+
+// Media status values used by jellyseerr/overseerr.
+const (
+	mediaStatusPartiallyAvailable = 4
+	mediaStatusAvailable          = 5
+)
+
+// Number of requests fetched per page.
+const pageSize = 100
+
+// Find the newest request for a movie (by TMDB id) or a series (by TVDB id).
+func FindRequest(apiURL, apiKey, mediaType string, id int) (Request, error) {
+	type RequestsResponseBody struct {
+		PageInfo struct {
+			Pages int `json:"pages"`
+		} `json:"pageInfo"`
+		Results []Request `json:"results"`
+	}
+
+	for page := 0; ; page++ {
+		var body RequestsResponseBody
+		err := send("GET", apiURL+"/request?sort=added&take="+strconv.Itoa(pageSize)+"&skip="+strconv.Itoa(page*pageSize), apiKey, nil, &body)
+		if err != nil {
+			return Request{}, err
+		}
+
+		for _, r := range body.Results {
+			if r.Media.MediaType != mediaType {
+				continue
+			}
+			if (mediaType == "movie" && r.Media.TmdbId == id) || (mediaType == "tv" && r.Media.TvdbId == id) {
+				return r, nil
+			}
+		}
+
+		if page+1 >= body.PageInfo.Pages {
+			break
+		}
+	}
+
+	return Request{}, fmt.Errorf("no jellyseerr request found for %v %v", mediaType, id)
+}
+
+// Mark the requested media as available, which makes jellyseerr notify the user that requested it. Jellyseerr marks
+// every season of a series at once, so a series is only marked as available when complete, partially available
+// otherwise.
+func MarkAvailable(apiURL, apiKey string, request Request, complete bool) error {
+	status := "available"
+	if request.Media.MediaType == "tv" && !complete {
+		status = "partial"
+	}
+
+	if (status == "available" && request.Media.Status == mediaStatusAvailable) || (status == "partial" && request.Media.Status == mediaStatusPartiallyAvailable) {
+		return nil
+	}
+
+	log.Printf("Marking jellyseerr media %v requested by %v as %v.", request.Media.Id, request.RequestedBy.DisplayName, status)
+	return send("POST", apiURL+"/media/"+strconv.Itoa(request.Media.Id)+"/"+status, apiKey, map[string]bool{"is4k": request.Is4k}, nil)
+}
+
+// Add a comment to every open issue of the media, e.g. to let the reporter know a new file was downloaded.
+func CommentIssues(apiURL, apiKey string, mediaId int, message string) error {
+	type Issue struct {
+		Id    int `json:"id"`
+		Media struct {
+			Id int `json:"id"`
+		} `json:"media"`
+	}
+	type IssuesResponseBody struct {
+		Results []Issue `json:"results"`
+	}
+
+	var body IssuesResponseBody
+	err := send("GET", apiURL+"/issue?filter=open&take="+strconv.Itoa(pageSize), apiKey, nil, &body)
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range body.Results {
+		if issue.Media.Id != mediaId {
+			continue
+		}
+
+		log.Printf("Commenting on jellyseerr issue %v.", issue.Id)
+		err = send("POST", apiURL+"/issue/"+strconv.Itoa(issue.Id)+"/comment", apiKey, map[string]string{"message": message}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send a request to jellyseerr with body encoded as JSON, if not nil, and decode the response into v, if not nil.
+func send(method, url, apiKey string, body, v interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.New("jellyseerr " + method + " " + req.URL.Path + " returned: " + resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,62 @@
+package lockfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Exclusive lock on a file shared by every debridGo process.
+type Lock struct {
+	f *os.File
+}
+
+// Block until the exclusive lock of path is acquired. The file is created if it doesn't exist.
+func Acquire(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Acquire the exclusive lock of path without blocking. ok is false if another file descriptor holds it.
+func TryAcquire(path string) (lock *Lock, ok bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		f.Close()
+		return nil, false, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+
+	return &Lock{f: f}, true, nil
+}
+
+// File held by the lock, for reading and writing state that must only be touched while locked.
+func (l *Lock) File() *os.File {
+	return l.f
+}
+
+func (l *Lock) Release() error {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
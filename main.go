@@ -2,25 +2,44 @@
 package main
 
 import (
+	"context"
+	"debridGo/archive"
+	"debridGo/bazarr"
 	"debridGo/config"
 	"debridGo/conversion"
+	"debridGo/jellyseerr"
 	"debridGo/mediaServer"
+	"debridGo/notifier"
+	"debridGo/rdebrid"
 	"debridGo/servarr"
 	"debridGo/types"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io/fs"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
 	// dir := flag.String("dir", "", "")
 	// rootDir := flag.String("rootDir", "", "")
 	// torrent := flag.String("torrent", "", "")
+	// "debridGo plan <file>" prints what the conversion would do with a file, without converting it.
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		planCommand(os.Args[2:])
+		return
+	}
+
 	saveDir := flag.String("saveDir", "", "")
 	rdtcHash := flag.String("hash", "", "")
 	// count := flag.Int64("count", 0, "")
@@ -47,6 +66,22 @@ func main() {
 		log.Fatalln("Could not get value from toml file: ", err)
 	}
 
+	// Notifications of the job events. A bad notify config is logged and notifications are disabled.
+	notify, err := notifier.New(conf)
+	if err != nil {
+		log.Println("Notifications disabled: ", err)
+	}
+
+	// "debridGo watch <hash> <title>" follows a grabbed torrent in Real-Debrid. The "On Grab" trigger starts it in the
+	// background.
+	if flag.Arg(0) == "watch" {
+		err = rdebrid.Watch(flag.Arg(1), flag.Arg(2), notify)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	// Get environment variables from sonarr or radarr using the custom script option and the "On Grab" trigger.
 	// Save these variables in a file so they can be used when "debridGo" is executed by "rdtClient".
 	var rclonePath string
@@ -55,37 +90,61 @@ func main() {
 	radarrInternalMovieID := os.Getenv("radarr_movie_id")
 	movieTitle := os.Getenv("radarr_movie_title")
 	movieYear := os.Getenv("radarr_movie_year")
+	movieTmdbID := os.Getenv("radarr_movie_tmdbid")
 	torrentHash := os.Getenv("radarr_download_id") // Torrent hash that comes from radarr/sonarr. Useful to match it with the torrent hash from rdtclient
 	rclonePath = conf.Rclone.RemoteName + ":" + conf.Rclone.MoviesDir + "/" + movieTitle + " (" + movieYear + ")" + "/"
+	releaseSize := os.Getenv("radarr_release_size")       // Size in bytes of the grabbed release
+	releaseQuality := os.Getenv("radarr_release_quality") // Quality of the grabbed release, e.g. Bluray-1080p
 
 	// SONARR env variables
 	sonarrInternalSeriesID := os.Getenv("sonarr_series_id")        // Internal ID of the series
 	seriesTitle := os.Getenv("sonarr_series_title")                // Title of the series
 	seriesSeasonNumber := os.Getenv("sonarr_release_seasonnumber") // Season number from release
+	episodeNumbers := os.Getenv("sonarr_release_episodenumbers")   // Comma separated episode numbers from release
+	seriesTvdbID := os.Getenv("sonarr_series_tvdbid")              // TVDB id of the series
 	if torrentHash == "" {
 		torrentHash = os.Getenv("sonarr_download_id") // Torrent hash that comes from radarr/sonarr. Useful to match it with the torrent hash from rdtclient
 		rclonePath = conf.Rclone.RemoteName + ":" + conf.Rclone.SeriesDir + "/" + seriesTitle + "/" + "Season " + seriesSeasonNumber + "/"
+		releaseSize = os.Getenv("sonarr_release_size")
+		releaseQuality = os.Getenv("sonarr_release_quality")
 	}
 
 	// if this script was triggered from sonarr/radarr save necessary data to a JSON file.
 	if torrentHash != "" {
 		// convert id from string to int and set correct category.
-		var id int
-		var category string
+		var id, seasonNumber, tmdbId, tvdbId int
+		var category, title string
+		var episodes []int
 		if radarrInternalMovieID != "" {
 			id, _ = strconv.Atoi(radarrInternalMovieID)
+			tmdbId, _ = strconv.Atoi(movieTmdbID)
 			category = "radarr"
+			title = movieTitle + " (" + movieYear + ")"
 		} else {
 			id, _ = strconv.Atoi(sonarrInternalSeriesID)
+			tvdbId, _ = strconv.Atoi(seriesTvdbID)
+			seasonNumber, _ = strconv.Atoi(seriesSeasonNumber)
+			for _, n := range strings.Split(episodeNumbers, ",") {
+				if e, err := strconv.Atoi(n); err == nil {
+					episodes = append(episodes, e)
+				}
+			}
 			category = "tv-sonarr"
+			title = seriesTitle + " - Season " + seriesSeasonNumber
 		}
 
 		// Instance of Data struct.
 		data := types.DataJSON{
-			TorrentHash: torrentHash,
-			ID:          id,
-			Category:    category,
-			RclonePath:  rclonePath,
+			TorrentHash:    torrentHash,
+			ID:             id,
+			Category:       category,
+			RclonePath:     rclonePath,
+			SeasonNumber:   seasonNumber,
+			EpisodeNumbers: episodes,
+			TmdbId:         tmdbId,
+			TvdbId:         tvdbId,
+			Title:          title,
+			Quality:        releaseQuality,
 		}
 
 		// Marshal the struct to JSON.
@@ -101,42 +160,107 @@ func main() {
 		}
 
 		log.Printf("Saving data to %v.json", strings.ToLower(torrentHash))
+
+		size, _ := strconv.ParseInt(releaseSize, 10, 64)
+		notify.Notify(notifier.Event{Type: notifier.Grabbed, Title: title, Size: size})
+
+		// Notify whether Real-Debrid has the release cached and blocklist it if Real-Debrid can't download it.
+		// sonarr/radarr wait for this script, so it runs apart.
+		if conf.DebridGo.RDapiKey != "" {
+			err = startWatch(torrentHash, title)
+			if err != nil {
+				log.Println("Could not watch torrent in Real-Debrid: ", err)
+			}
+		}
+
+		// The release has been grabbed, other searches for the same episodes are not needed anymore.
+		if category == "tv-sonarr" && conf.Sonarr.StopDuplicateSearches {
+			stopDuplicateSearches(conf, data)
+		}
 	}
 
 	// This section gets triggered by rdtclient when a download finishes.
 	if *saveDir != "" {
 
+		// Get values from rdtcHash.json located in rdtclient root download directory.
+		jsonFile, err := os.ReadFile(conf.DebridGo.DownloadDir + "/" + *rdtcHash + ".json")
+		if err != nil {
+			fail(notify, filepath.Base(*saveDir), err)
+		}
+
+		var data types.DataJSON
+		err = json.Unmarshal(jsonFile, &data)
+		if err != nil {
+			fail(notify, filepath.Base(*saveDir), err)
+		}
+
+		title := data.Title
+		if title == "" {
+			title = filepath.Base(*saveDir)
+		}
+
+		notify.Notify(notifier.Event{Type: notifier.Downloaded, Title: title, Size: dirSize(*saveDir)})
+
+		// Stopping debridGo (rdtclient timeout, service restart...) cancels the extraction and the conversion, which puts
+		// the source file back.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Extract RAR/ZIP/7z releases, their media is converted like the rest. The archives aren't uploaded. An archive
+		// with media that can't be extracted fails the job, the release is incomplete without it.
+		archives, err := archive.ExtractAll(ctx, conf, *saveDir)
+		if err != nil {
+			fail(notify, title, fmt.Errorf("could not extract archives: %w", err))
+		}
+		if len(archives) > 0 {
+			log.Printf("Extracted %v archives.", len(archives))
+		}
+
 		// Get the full path of video files in saveDir.
 		files, err := getVideoFiles(*saveDir)
 		if err != nil {
-			log.Fatalln(err)
+			fail(notify, title, err)
+		}
+		if len(files) == 0 {
+			fail(notify, title, errors.New("no video files in "+*saveDir))
 		}
 
-		// Convert all video files in the saveDir one at a time. This will create new .mp4 video files and new .vtt subtitle files.
-		for _, file := range files {
-			err = conversion.Video(file)
-			if err != nil {
-				log.Fatalln(err)
-			}
+		profile, err := conversion.Profile(conf, data.Category, data.Quality)
+		if err != nil {
+			fail(notify, title, err)
 		}
 
-		// Get values from rdtcHash.json located in rdtclient root download directory.
-		jsonFile, err := os.ReadFile(conf.DebridGo.DownloadDir + "/" + *rdtcHash + ".json")
+		// Remove the samples, set the extras aside and flatten the subdirectories, only the movie or episodes and the
+		// extras to convert are left.
+		files, extras, err := arrangeMedia(conf, data.Category, *saveDir, files)
 		if err != nil {
-			log.Fatalln(err)
+			fail(notify, title, err)
 		}
 
-		var data types.DataJSON
-		err = json.Unmarshal(jsonFile, &data)
+		// Convert the video files in the saveDir in parallel, up to the ffmpeg slots shared with other debridGo processes.
+		// This will create new video files and new subtitle files as set by the profile.
+		start := time.Now()
+		files, err = convertFiles(ctx, conf, notify, data.Category, files, profile)
 		if err != nil {
-			log.Fatalln(err)
+			fail(notify, title, err)
+		}
+		if len(files) > 0 {
+			notify.Notify(notifier.Event{Type: notifier.Converted, Title: title, Duration: time.Since(start)})
 		}
 
-		// Copy to destination using rclone.
-		err = servarr.CopyToDst(*saveDir, data.RclonePath)
+		// Keep the subtitle files that came with the download, named after their video.
+		_, err = conversion.ImportSubtitles(ctx, *saveDir, files, profile)
 		if err != nil {
-			log.Fatalln(err)
+			log.Println("Could not import subtitles: ", err)
+		}
+
+		// Copy to destination using rclone. Only the media and their subtitles are uploaded.
+		start = time.Now()
+		err = servarr.CopyToDst(*saveDir, data.RclonePath, append(files, extras...))
+		if err != nil {
+			fail(notify, title, err)
 		}
+		notify.Notify(notifier.Event{Type: notifier.Uploaded, Title: title, Size: dirSize(*saveDir), Duration: time.Since(start)})
 
 		// Check sonarr/radarr for new added files.
 		if data.Category == "tv-sonarr" {
@@ -150,24 +274,34 @@ func main() {
 		}
 
 		if data.Category == "radarr" {
-			err = servarr.RescanRadarr(data.ID, conf.Radarr.ApiURL, conf.Radarr.ApiKey, conf.Bazarr.ApiURL, conf.Bazarr.ApiKey)
+			err = servarr.RescanRadarr(data.ID, conf.Radarr.ApiURL, conf.Radarr.ApiKey)
 			if err != nil {
-				log.Fatalln(err)
+				fail(notify, title, err)
 			}
 			log.Println("Movie rescanned successfully.")
 
 		}
 
-		// Once everything is ready and where it belongs, send a request to emby/jellyfin to scan the library. And then to Jellyseerr
-		err = mediaServer.ScanEmby(conf.Emby.ApiURL, conf.Emby.ApiKey)
+		// Search subtitles in bazarr for the new files.
+		if conf.Bazarr.ApiURL != "" {
+			searchSubtitles(conf, data)
+		}
+
+		// Once everything is ready and where it belongs, send a request to emby/jellyfin/plex to scan the library. And then to Jellyseerr
+		server, err := mediaServer.New(conf)
 		if err != nil {
-			log.Fatalln(err)
+			fail(notify, title, err)
 		}
 
-		// Sync Jellyseerr.
-		err = mediaServer.SyncJellyseerr(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey)
+		// Jobs finishing around the same time share the scan and the Jellyseerr sync.
+		err = mediaServer.ScanAndSync(server, conf, data.RclonePath)
 		if err != nil {
-			log.Fatalln(err)
+			fail(notify, title, err)
+		}
+
+		// Let the user that requested the media know it is available.
+		if conf.Jellyseerr.ApiURL != "" && (conf.Jellyseerr.MarkAvailable || conf.Jellyseerr.CommentIssues) {
+			notifyJellyseerr(conf, data)
 		}
 
 		// Remove saveDir and json file previously created.
@@ -188,25 +322,373 @@ func main() {
 	}
 }
 
+// Print the conversion plan of a file as JSON.
+func planCommand(args []string) {
+	planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+	category := planFlags.String("category", "", "Category used to select the conversion profile: radarr or tv-sonarr")
+	quality := planFlags.String("quality", "", "Release quality used to select the conversion profile, e.g. Bluray-1080p")
+	devices := planFlags.String("devices", "", "Comma separated devices to check instead of the ones of the profile, e.g. chromecast,firetv")
+	planFlags.Parse(args)
+
+	if planFlags.NArg() != 1 {
+		log.Fatalln("Usage: debridGo plan [-category radarr|tv-sonarr] [-quality quality] [-devices devices] <file>")
+	}
+
+	conf, err := config.Values()
+	if err != nil {
+		log.Fatalln("Could not get value from toml file: ", err)
+	}
+
+	profile, err := conversion.Profile(conf, *category, *quality)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *devices != "" {
+		profile.Devices = strings.Split(*devices, ",")
+		profile.DeviceProfiles, err = conversion.DeviceProfiles(conf, profile.Devices)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	plan, err := conversion.PlanFile(planFlags.Arg(0), profile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	jsonData, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(string(jsonData))
+}
+
+// Convert files with up to MaxProcesses workers, each one waiting for a free ffmpeg slot, and get the paths of the
+// converted files, in the order of files. The first error cancels the remaining conversions.
+func convertFiles(ctx context.Context, conf types.TomlConfig, notify *notifier.Notifier, category string, files []string, profile types.ConversionProfile) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	converted := make([]string, len(files))
+	jobs := make(chan int)
+	errs := make(chan error, len(files))
+	var wg sync.WaitGroup
+
+	workers := conversion.MaxProcesses(conf)
+	if workers > len(files) {
+		workers = len(files)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var err error
+				converted[i], err = convertFile(ctx, conf, notify, category, files[i], profile)
+				if err != nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	// The first error is the cause, the rest are conversions cancelled because of it.
+	return converted, <-errs
+}
+
+func convertFile(ctx context.Context, conf types.TomlConfig, notify *notifier.Notifier, category, file string, profile types.ConversionProfile) (string, error) {
+	if ctx.Err() != nil {
+		return file, ctx.Err()
+	}
+
+	slot, err := conversion.AcquireSlot(ctx, conf, category, file)
+	if err != nil {
+		return file, err
+	}
+	defer slot.Release()
+
+	return conversion.Video(ctx, file, profile, reportProgress(notify))
+}
+
+// Log the progress of a conversion every 10% and notify it every 25%.
+func reportProgress(notify *notifier.Notifier) conversion.ProgressFunc {
+	next, nextNotify := 0.0, 25.0
+	return func(p conversion.Progress) {
+		if p.Percent < next && !p.Done {
+			return
+		}
+		next = float64(int(p.Percent/10)+1) * 10
+
+		if p.Done {
+			log.Printf("Converted %v (%.2fx)", filepath.Base(p.File), p.Speed)
+			return
+		}
+		log.Printf("Converting %v %.0f%% ---- %.2fx, ETA %v", filepath.Base(p.File), p.Percent, p.Speed, p.ETA.Round(time.Second))
+
+		if p.Percent >= nextNotify && p.Percent < 100 {
+			notify.Notify(notifier.Event{Type: notifier.Progress, Title: filepath.Base(p.File), Percent: p.Percent, ETA: p.ETA.Round(time.Second)})
+			nextNotify = float64(int(p.Percent/25)+1) * 25
+		}
+	}
+}
+
+// Start "debridGo watch" for a torrent in its own session, so it outlives the "On Grab" trigger.
+func startWatch(hash, title string) error {
+	ex, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(ex, "watch", hash, title)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// Notify the failure of the job and exit.
+func fail(notify *notifier.Notifier, title string, err error) {
+	notify.Notify(notifier.Event{Type: notifier.Failed, Title: title, Err: err})
+	log.Fatalln(err)
+}
+
+// Total size in bytes of the files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Mark the jellyseerr request of the job as available and comment on the open issues of its media. Errors are logged but not fatal.
+func notifyJellyseerr(conf types.TomlConfig, data types.DataJSON) {
+	var request jellyseerr.Request
+	var err error
+	if data.Category == "radarr" {
+		request, err = jellyseerr.FindRequest(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey, "movie", data.TmdbId)
+	} else {
+		request, err = jellyseerr.FindRequest(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey, "tv", data.TvdbId)
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if conf.Jellyseerr.MarkAvailable {
+		err = jellyseerr.MarkAvailable(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey, request, seriesComplete(conf, data))
+		if err != nil {
+			log.Println("Could not mark jellyseerr request as available: ", err)
+		}
+	}
+
+	if conf.Jellyseerr.CommentIssues {
+		message := "A new file has been downloaded by debridGo."
+		if data.Category == "tv-sonarr" {
+			message = fmt.Sprintf("New files for season %v have been downloaded by debridGo.", data.SeasonNumber)
+		}
+		err = jellyseerr.CommentIssues(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey, request.Media.Id, message)
+		if err != nil {
+			log.Println("Could not comment on jellyseerr issues: ", err)
+		}
+	}
+}
+
+// Whether every monitored season of the series of the job has all its files in sonarr. Movies are always complete. An
+// unknown series or an error counts as not complete.
+func seriesComplete(conf types.TomlConfig, data types.DataJSON) bool {
+	if data.Category != "tv-sonarr" {
+		return true
+	}
+	if data.ID == 0 {
+		return false
+	}
+
+	complete, err := servarr.SeriesComplete(data.ID, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+	if err != nil {
+		log.Println("Could not check whether the series is complete: ", err)
+		return false
+	}
+	return complete
+}
+
+// Stop the queued sonarr searches for the episodes of the grabbed release. Errors are logged but not fatal.
+func stopDuplicateSearches(conf types.TomlConfig, data types.DataJSON) {
+	episodes, err := servarr.Episodes(data.ID, data.SeasonNumber, data.EpisodeNumbers, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+	if err != nil {
+		log.Println("Could not stop duplicate searches: ", err)
+		return
+	}
+
+	var episodeIds []int
+	for _, e := range episodes {
+		episodeIds = append(episodeIds, e.Id)
+	}
+	if len(episodeIds) == 0 {
+		return
+	}
+
+	stopped, err := servarr.StopEpisodeSearch(data.ID, episodeIds, conf.Sonarr.StopSearchesDryRun, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+	if err != nil {
+		log.Println("Could not stop duplicate searches: ", err)
+	}
+
+	if conf.Sonarr.StopSearchesDryRun {
+		log.Printf("Dry run. %v duplicate searches would have been stopped.", len(stopped))
+	} else {
+		log.Printf("%v duplicate searches stopped.", len(stopped))
+	}
+}
+
+// Ask bazarr to search subtitles for the movie or episodes of the job and log the languages found. Errors are logged but not fatal.
+func searchSubtitles(conf types.TomlConfig, data types.DataJSON) {
+	// One deadline for every search of the job, so a season pack doesn't hold the job for an episode each.
+	ctx, cancel := context.WithTimeout(context.Background(), bazarr.SearchTimeout)
+	defer cancel()
+
+	if data.Category == "radarr" {
+		movieFileId, moviePath, err := servarr.MovieFile(data.ID, conf.Radarr.ApiURL, conf.Radarr.ApiKey)
+		if err != nil {
+			log.Println("Could not search subtitles in bazarr: ", err)
+			return
+		}
+
+		subtitles, err := bazarr.SearchMovie(ctx, conf.Bazarr.ApiURL, conf.Bazarr.ApiKey, data.ID, movieFileId, moviePath)
+		if err != nil {
+			log.Println("Could not search subtitles in bazarr: ", err)
+			return
+		}
+		log.Println("Bazarr subtitles for movie: ", bazarr.Languages(subtitles))
+	}
+
+	if data.Category == "tv-sonarr" {
+		episodes, err := servarr.EpisodesWithFile(data.ID, data.SeasonNumber, data.EpisodeNumbers, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+		if err != nil {
+			log.Println("Could not search subtitles in bazarr: ", err)
+			return
+		}
+		paths, err := servarr.EpisodeFilePaths(data.ID, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+		if err != nil {
+			log.Println("Could not search subtitles in bazarr: ", err)
+			return
+		}
+
+		for n, e := range episodes {
+			if ctx.Err() != nil {
+				log.Printf("Bazarr subtitles search timed out, %v episodes left.", len(episodes)-n)
+				return
+			}
+
+			subtitles, err := bazarr.SearchEpisode(ctx, conf.Bazarr.ApiURL, conf.Bazarr.ApiKey, e.Id, e.EpisodeFileId, paths[e.EpisodeFileId])
+			if err != nil {
+				log.Printf("Could not search subtitles in bazarr for S%02dE%02d: %v", e.SeasonNumber, e.EpisodeNumber, err)
+				continue
+			}
+			log.Printf("Bazarr subtitles for S%02dE%02d: %v", e.SeasonNumber, e.EpisodeNumber, bazarr.Languages(subtitles))
+		}
+	}
+}
+
+// Classify the video files of saveDir, remove the samples, move the extras into the extras directory or remove them, and
+// move the movie or episodes out of their subdirectories, as set for the category. Returns the files to convert and the
+// extras uploaded as they are.
+func arrangeMedia(conf types.TomlConfig, category, saveDir string, files []string) (convert, extras []string, err error) {
+	action, err := conversion.ExtrasAction(conf, category)
+	if err != nil {
+		return nil, nil, err
+	}
+	layout, err := conversion.Layout(conf, category)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	media, err := conversion.Classify(saveDir, files, category)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, m := range media {
+		log.Printf("%v is %v: %v", m.Path, m.Kind, m.Reason)
+
+		switch {
+		case m.Kind == conversion.MediaSample || m.Kind == conversion.MediaExtra && action == conversion.ExtrasDiscard:
+			err = os.Remove(m.Path)
+		case m.Kind == conversion.MediaExtra:
+			var extra string
+			extra, err = moveMedia(m.Path, filepath.Join(saveDir, servarr.ExtrasDir))
+			if action == conversion.ExtrasConvert {
+				convert = append(convert, extra)
+			} else {
+				extras = append(extras, extra)
+			}
+		case layout == conversion.LayoutFlatten:
+			var file string
+			file, err = moveMedia(m.Path, saveDir)
+			convert = append(convert, file)
+		default:
+			convert = append(convert, m.Path)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return convert, extras, nil
+}
+
+// Move a media file into dir and get its new path. A file with the same name already in dir is not replaced, the media
+// file gets the name of its directory as prefix instead. Its subtitles are found by ImportSubtitles where they are.
+func moveMedia(path, dir string) (string, error) {
+	if filepath.Dir(path) == filepath.Clean(dir) {
+		return path, nil
+	}
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return path, err
+	}
+
+	dst := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dst); err == nil {
+		dst = filepath.Join(dir, filepath.Base(filepath.Dir(path))+" - "+filepath.Base(path))
+	}
+
+	return dst, os.Rename(path, dst)
+}
+
+// Full paths of the mp4 and mkv files of saveDir and its subdirectories.
 func getVideoFiles(saveDir string) ([]string, error) {
 	var files []string
 
-	filepath.WalkDir(saveDir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(saveDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if d.IsDir() && d.Name() != filepath.Base(saveDir) {
-			return filepath.SkipDir
-		}
-
 		// Append files for video conversion. Only mp4 and mkv are wanted.
-		if strings.HasSuffix(d.Name(), "mp4") || strings.HasSuffix(d.Name(), "mkv") {
-			files = append(files, saveDir+"/"+d.Name())
+		ext := strings.ToLower(filepath.Ext(d.Name()))
+		if !d.IsDir() && (ext == ".mp4" || ext == ".mkv") {
+			files = append(files, path)
 		}
 
 		return nil
 	})
 
-	return files, nil
+	return files, err
 }
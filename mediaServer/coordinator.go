@@ -0,0 +1,210 @@
+package mediaServer
+
+import (
+	"debridGo/lockfile"
+	"debridGo/types"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	queuePollInterval = 5 * time.Second  // Interval between checks of a batch run by another process.
+	heartbeatInterval = 30 * time.Second // Interval at which the leader of a batch lets the others know it is alive.
+	staleAfter        = 3 * time.Minute  // Time without heartbeat after which the leader of a batch is considered dead.
+)
+
+// Scan requests shared by every debridGo process, saved in the download directory.
+// Requests arriving while a batch is open join it and the process that opened it (the leader) runs one scan for all of them.
+type scanQueue struct {
+	Batch   int               `json:"batch"`   // Id of the newest batch.
+	Open    bool              `json:"open"`    // Whether the newest batch still accepts paths.
+	Paths   []string          `json:"paths"`   // rclone paths of the open batch.
+	Running map[int]time.Time `json:"running"` // Last time the leader of each unfinished batch was alive, by batch id.
+	Results map[int]string    `json:"results"` // Error of each finished batch, empty if it succeeded, by batch id.
+}
+
+// Results of older batches are forgotten. Their waiters are long gone.
+const keptResults = 100
+
+// Refresh rclonePath on the media server and sync jellyseerr. Requests from all the jobs finishing within ScanDelay are
+// batched into one refresh per library and one jellyseerr sync. Blocks until the batch that includes rclonePath finishes.
+func ScanAndSync(server MediaServer, conf types.TomlConfig, rclonePath string) error {
+	queueFile := conf.DebridGo.DownloadDir + "/scan-queue.json"
+
+	var batch int
+	var leader bool
+	err := updateQueue(queueFile, func(q *scanQueue) {
+		if !q.Open {
+			q.Batch++
+			q.Open = true
+			q.Paths = nil
+			q.Running[q.Batch] = time.Now()
+			leader = true
+		}
+		q.Paths = append(q.Paths, rclonePath)
+		batch = q.Batch
+	})
+	if err != nil {
+		return err
+	}
+
+	if !leader {
+		log.Printf("Scan of %v queued in batch %v.", rclonePath, batch)
+		return waitForBatch(server, conf, queueFile, batch)
+	}
+
+	log.Printf("Scan of %v opened batch %v.", rclonePath, batch)
+	return leadBatch(server, conf, queueFile, batch, true)
+}
+
+// Run the batch and save its result to the queue. With wait, the batch stays open for ScanDelay to collect other requests.
+func leadBatch(server MediaServer, conf types.TomlConfig, queueFile string, batch int, wait bool) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go heartbeat(queueFile, batch, stop)
+
+	if wait {
+		log.Printf("Waiting %v for other scan requests...", scanDelay(conf))
+		time.Sleep(scanDelay(conf))
+	}
+
+	var paths []string
+	err := updateQueue(queueFile, func(q *scanQueue) {
+		if q.Open && q.Batch == batch {
+			q.Open = false
+			paths = q.Paths
+			q.Paths = nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	scanErr := scan(server, conf, paths)
+	if scanErr == nil && conf.Jellyseerr.ApiURL != "" {
+		scanErr = SyncJellyseerr(conf.Jellyseerr.ApiURL, conf.Jellyseerr.ApiKey, server)
+	}
+
+	err = updateQueue(queueFile, func(q *scanQueue) {
+		delete(q.Running, batch)
+		q.Results[batch] = ""
+		if scanErr != nil {
+			q.Results[batch] = scanErr.Error()
+		}
+		for b := range q.Results {
+			if b <= q.Batch-keptResults {
+				delete(q.Results, b)
+			}
+		}
+	})
+	if err != nil {
+		log.Println("Could not save scan result: ", err)
+	}
+
+	return scanErr
+}
+
+// Block until another process finishes the batch. If its leader dies, this process takes over the batch. Every batch
+// has its own leader and heartbeat, so a newer batch finishing says nothing about this one.
+func waitForBatch(server MediaServer, conf types.TomlConfig, queueFile string, batch int) error {
+	for {
+		time.Sleep(queuePollInterval)
+
+		var done, takeOver bool
+		var batchErr string
+		err := updateQueue(queueFile, func(q *scanQueue) {
+			if result, ok := q.Results[batch]; ok {
+				done = true
+				batchErr = result
+				return
+			}
+			// A batch without heartbeat has no leader left, e.g. after a crash between its end and its result.
+			if heartbeat, ok := q.Running[batch]; !ok || time.Since(heartbeat) > staleAfter {
+				q.Running[batch] = time.Now()
+				takeOver = true
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if done {
+			if batchErr != "" {
+				return errors.New(batchErr)
+			}
+			return nil
+		}
+
+		if takeOver {
+			log.Printf("Leader of scan batch %v stopped responding. Taking over.", batch)
+			return leadBatch(server, conf, queueFile, batch, false)
+		}
+	}
+}
+
+func heartbeat(queueFile string, batch int, stop chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// The batch may have finished since the tick.
+			err := updateQueue(queueFile, func(q *scanQueue) {
+				if _, ok := q.Running[batch]; ok {
+					q.Running[batch] = time.Now()
+				}
+			})
+			if err != nil {
+				log.Println("Could not update scan heartbeat: ", err)
+			}
+		}
+	}
+}
+
+// Read, modify and write the scan queue while holding its lock.
+func updateQueue(queueFile string, update func(q *scanQueue)) error {
+	lock, err := lockfile.Acquire(queueFile)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	f := lock.File()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var q scanQueue
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &q)
+		if err != nil {
+			return err
+		}
+	}
+	if q.Running == nil {
+		q.Running = make(map[int]time.Time)
+	}
+	if q.Results == nil {
+		q.Results = make(map[int]string)
+	}
+
+	update(&q)
+
+	data, err = json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, 0)
+	return err
+}
@@ -0,0 +1,166 @@
+package mediaServer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Emby media server.
+type Emby struct {
+	ApiURL string
+	ApiKey string
+}
+
+func (e *Emby) Name() string {
+	return "Emby"
+}
+
+func (e *Emby) Refresh() error {
+	return embyRefresh(e.ApiURL, e.ApiKey)
+}
+
+func (e *Emby) RefreshPaths(paths []string) error {
+	return embyRefreshPaths(e.ApiURL, e.ApiKey, paths)
+}
+
+func (e *Emby) WaitForScan(ctx context.Context) error {
+	return embyWaitForScan(ctx, e.Name(), e.ApiURL, e.ApiKey)
+}
+
+// Jellyfin media server. Jellyfin kept Emby's API so both share the same requests.
+type Jellyfin struct {
+	ApiURL string
+	ApiKey string
+}
+
+func (j *Jellyfin) Name() string {
+	return "Jellyfin"
+}
+
+func (j *Jellyfin) Refresh() error {
+	return embyRefresh(j.ApiURL, j.ApiKey)
+}
+
+func (j *Jellyfin) RefreshPaths(paths []string) error {
+	return embyRefreshPaths(j.ApiURL, j.ApiKey, paths)
+}
+
+func (j *Jellyfin) WaitForScan(ctx context.Context) error {
+	return embyWaitForScan(ctx, j.Name(), j.ApiURL, j.ApiKey)
+}
+
+func embyRefresh(apiUrl, apiKey string) error {
+	client := &http.Client{}
+
+	req, err := http.NewRequest("POST", apiUrl+"/Library/Refresh", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("library refresh returned: %v", resp.Status)
+	}
+
+	return nil
+}
+
+// Let the server know the paths have new media so only those paths are scanned.
+func embyRefreshPaths(apiUrl, apiKey string, paths []string) error {
+	type Update struct {
+		Path       string `json:"Path"`
+		UpdateType string `json:"UpdateType"`
+	}
+	type Body struct {
+		Updates []Update `json:"Updates"`
+	}
+
+	var body Body
+	for _, p := range paths {
+		body.Updates = append(body.Updates, Update{Path: p, UpdateType: "Created"})
+	}
+
+	postBodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest("POST", apiUrl+"/Library/Media/Updated", bytes.NewBuffer(postBodyJSON))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("media updated request returned: %v", resp.Status)
+	}
+
+	return nil
+}
+
+// Block until the RefreshLibrary scheduled task is idle.
+func embyWaitForScan(ctx context.Context, name, apiUrl, apiKey string) error {
+	type EmbyTasksResponseBody struct {
+		Key   string `json:"Key"`
+		State string `json:"State"`
+	}
+
+	client := &http.Client{}
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiUrl+"/ScheduledTasks", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Emby-Token", apiKey)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		// Get the status of the library scan.
+		var embyTasks []EmbyTasksResponseBody
+		err = json.NewDecoder(resp.Body).Decode(&embyTasks)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		scanning := false
+		for _, task := range embyTasks {
+			if task.Key == "RefreshLibrary" && task.State != "Idle" {
+				scanning = true
+			}
+		}
+		if !scanning {
+			return nil
+		}
+
+		log.Printf("%v is performing a library scan. Checking again in %v", name, scanPollInterval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scanPollInterval):
+		}
+	}
+}
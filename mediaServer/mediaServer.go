@@ -1,98 +1,170 @@
 package mediaServer
 
 import (
+	"context"
+	"debridGo/types"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func ScanEmby(apiUrl, apiKey string) error {
+const (
+	scanPollInterval = 10 * time.Second // Interval between checks of a running library scan.
+	scanTimeout      = time.Hour        // Max time to wait for a library scan to finish.
+	syncTimeout      = 30 * time.Minute // Max time to wait for a jellyseerr sync to finish.
+)
 
-	log.Println("Sending request to refresh library to Emby in 60 seconds...")
-	time.Sleep(60000 * time.Millisecond)
+// Media server whose libraries get refreshed once new files are uploaded.
+type MediaServer interface {
+	Name() string
+	// Request a refresh of all the libraries.
+	Refresh() error
+	// Request a refresh of directories, as seen by the media server. Each library is refreshed once.
+	RefreshPaths(paths []string) error
+	// Block until the server has no library scan running, or ctx is done.
+	WaitForScan(ctx context.Context) error
+}
 
-	log.Println("Check if emby is performing a library scan.")
-	err := checkScanEmby(apiUrl, apiKey)
-	if err != nil {
-		return err
+// Media server selected by the Type of the mediaserver section of the config. Emby is used if no type is set.
+func New(conf types.TomlConfig) (MediaServer, error) {
+	switch strings.ToLower(conf.MediaServer.Type) {
+	case "", "emby":
+		return &Emby{ApiURL: conf.Emby.ApiURL, ApiKey: conf.Emby.ApiKey}, nil
+	case "jellyfin":
+		return &Jellyfin{ApiURL: conf.Jellyfin.ApiURL, ApiKey: conf.Jellyfin.ApiKey}, nil
+	case "plex":
+		return &Plex{ApiURL: conf.Plex.ApiURL, Token: conf.Plex.Token}, nil
 	}
 
-	log.Println("Emby is ready to perform a library scan.")
-
-	client := &http.Client{}
+	return nil, errors.New("unknown media server type: " + conf.MediaServer.Type)
+}
 
-	req, err := http.NewRequest("POST", apiUrl+"/Library/Refresh?api_key="+apiKey, nil)
+// Refresh the media server and wait until the scan finishes. When every rclone path maps to a directory of the media server
+// only those directories are refreshed, otherwise all the libraries are. Each wait for a scan gives up after scanTimeout.
+func scan(server MediaServer, conf types.TomlConfig, rclonePaths []string) error {
+	log.Printf("Check if %v is performing a library scan.", server.Name())
+	err := waitForScan(server)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	_, err = client.Do(req)
-	if err != nil {
-		return err
+
+	log.Printf("%v is ready to perform a library scan.", server.Name())
+
+	var localPaths []string
+	for _, p := range rclonePaths {
+		localPath, ok := LocalPath(conf, p)
+		if !ok {
+			log.Printf("No path mapping for %v, refreshing all the libraries.", p)
+			localPaths = nil
+			break
+		}
+		if !containsString(localPaths, localPath) {
+			localPaths = append(localPaths, localPath)
+		}
+	}
+
+	if len(localPaths) > 0 {
+		err = server.RefreshPaths(localPaths)
+		if err != nil {
+			return err
+		}
+		log.Printf("Refresh request for %v sent to %v.", localPaths, server.Name())
+	} else {
+		err = server.Refresh()
+		if err != nil {
+			return err
+		}
+		log.Printf("Refresh request sent to %v.", server.Name())
 	}
-	log.Println("Refresh request sent to Emby.")
 
-	log.Println("Check if Emby finished the just requested library scan.")
-	err = checkScanEmby(apiUrl, apiKey)
+	// Give the server a moment to start the scan before checking its status.
+	time.Sleep(5000 * time.Millisecond)
+
+	log.Printf("Check if %v finished the just requested library scan.", server.Name())
+	err = waitForScan(server)
 	if err != nil {
 		return err
 	}
 
-	log.Println("Emby finished scanning the library.")
+	log.Printf("%v finished scanning the library.", server.Name())
 
 	return nil
 }
 
-func checkScanEmby(apiUrl, apiKey string) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", apiUrl+"/ScheduledTasks?api_key="+apiKey, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("x-api-key", apiKey)
+func waitForScan(server MediaServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	err := server.WaitForScan(ctx)
+	if ctx.Err() != nil {
+		return fmt.Errorf("%v library scan still running after %v: %w", server.Name(), scanTimeout, ctx.Err())
 	}
-	defer resp.Body.Close()
+	return err
+}
 
-	bodyResp, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+// Time to wait after the upload before refreshing, so the media server can see the new files.
+func scanDelay(conf types.TomlConfig) time.Duration {
+	if conf.MediaServer.ScanDelay > 0 {
+		return time.Duration(conf.MediaServer.ScanDelay) * time.Second
 	}
+	return 60 * time.Second
+}
 
-	// Get the status of the library scan.
-	type EmbyTasksResponseBody struct {
-		Key   string `json:"Key"`
-		State string `json:"State"`
+// Translate an rclone destination into the path the media server sees, using the longest matching path mapping.
+func LocalPath(conf types.TomlConfig, rclonePath string) (string, bool) {
+	rclonePath = strings.TrimSuffix(rclonePath, "/")
+
+	var local, matched string
+	for _, m := range conf.MediaServer.PathMappings {
+		prefix := strings.TrimSuffix(m.Rclone, "/")
+		if !isSubPath(prefix, rclonePath) || len(prefix) <= len(matched) {
+			continue
+		}
+		matched = prefix
+		local = strings.TrimSuffix(m.Local, "/") + strings.TrimPrefix(rclonePath, prefix)
 	}
-	var embyTasks []EmbyTasksResponseBody
-	err = json.Unmarshal(bodyResp, &embyTasks)
-	if err != nil {
-		return err
+
+	return local, matched != ""
+}
+
+// Closest directory containing all paths.
+func commonDir(paths []string) string {
+	dir := strings.TrimSuffix(paths[0], "/")
+	for _, p := range paths[1:] {
+		for !isSubPath(dir, p) && dir != "" {
+			dir = dir[:strings.LastIndex(dir, "/")+1]
+			dir = strings.TrimSuffix(dir, "/")
+		}
 	}
+	return dir
+}
 
-	for _, task := range embyTasks {
-		if task.Key == "RefreshLibrary" && task.State != "Idle" {
-			log.Println("Emby is performing a library scan. Checking again in 10 seconds")
-			resp.Body.Close()
-			time.Sleep(10000 * time.Millisecond)
-			checkScanEmby(apiUrl, apiKey)
-			return nil
+func containsString(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
 		}
 	}
+	return false
+}
 
-	resp.Body.Close()
-	return nil
+// Whether path is dir or inside dir.
+func isSubPath(dir, path string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return path == dir || strings.HasPrefix(path, dir+"/")
 }
 
-func SyncJellyseerr(apiURL, apiKey string) error {
+// Run the recently added sync job of jellyseerr for the media server in use and wait until it finishes, at most
+// syncTimeout.
+func SyncJellyseerr(apiURL, apiKey string, server MediaServer) error {
+	jobId := recentlyAddedJob(server)
 
 	client := &http.Client{}
-	req, err := http.NewRequest("POST", apiURL+"/settings/jobs/jellyfin-recently-added-sync/run", nil)
+	req, err := http.NewRequest("POST", apiURL+"/settings/jobs/"+jobId+"/run", nil)
 	if err != nil {
 		return err
 	}
@@ -104,9 +176,8 @@ func SyncJellyseerr(apiURL, apiKey string) error {
 	}
 	defer resp.Body.Close()
 
-	bodyResp, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("jellyseerr job %v returned: %v", jobId, resp.Status)
 	}
 
 	// Get the status of the library scan.
@@ -114,67 +185,78 @@ func SyncJellyseerr(apiURL, apiKey string) error {
 		Running bool `json:"running"`
 	}
 	var jellyseerrLibScanBody JellyseerrLibScanResponseBody
-	err = json.Unmarshal(bodyResp, &jellyseerrLibScanBody)
+	err = json.NewDecoder(resp.Body).Decode(&jellyseerrLibScanBody)
 	if err != nil {
 		return err
 	}
 
 	if jellyseerrLibScanBody.Running {
-		log.Println("Jellyseerr full library scan is running. Checking again in 10 seconds")
-		resp.Body.Close()
-		time.Sleep(10000 * time.Millisecond)
-		checkJellyseerrSync(apiURL, apiKey)
-		return nil
+		ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+		defer cancel()
+		err = waitForJellyseerrSync(ctx, apiURL, apiKey, jobId)
+		if ctx.Err() != nil {
+			return fmt.Errorf("jellyseerr job %v still running after %v: %w", jobId, syncTimeout, ctx.Err())
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	resp.Body.Close()
-	log.Println("Jellyseerr full library scan completed.")
+	log.Println("Jellyseerr library scan completed.")
 
 	return nil
 }
 
-func checkJellyseerrSync(apiURL, apiKey string) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", apiURL+"/settings/jobs", nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("x-api-key", apiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	bodyResp, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
+func waitForJellyseerrSync(ctx context.Context, apiURL, apiKey, jobId string) error {
 	// Get the status of the library scan.
 	type JellyseerrJobs struct {
 		Id      string `json:"id"`
 		Running bool   `json:"running"`
 	}
-	var jellyseerrJobs []JellyseerrJobs
-	err = json.Unmarshal(bodyResp, &jellyseerrJobs)
-	if err != nil {
-		return err
-	}
 
-	for _, job := range jellyseerrJobs {
-		if job.Running && job.Id == "jellyfin-recently-added-sync" {
-			log.Println("Jellyseerr full library scan is running. Checking again in 10 seconds")
-			resp.Body.Close()
-			time.Sleep(10000 * time.Millisecond)
-			checkJellyseerrSync(apiURL, apiKey)
+	client := &http.Client{}
+	for {
+		log.Printf("Jellyseerr library scan is running. Checking again in %v", scanPollInterval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scanPollInterval):
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL+"/settings/jobs", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("x-api-key", apiKey)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var jellyseerrJobs []JellyseerrJobs
+		err = json.NewDecoder(resp.Body).Decode(&jellyseerrJobs)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		running := false
+		for _, job := range jellyseerrJobs {
+			if job.Running && job.Id == jobId {
+				running = true
+			}
+		}
+		if !running {
 			return nil
 		}
 	}
+}
 
-	resp.Body.Close()
-	log.Println("Jellyseerr full library scan completed.")
-
-	return nil
+// Id of the jellyseerr job that syncs recently added media from the media server.
+func recentlyAddedJob(server MediaServer) string {
+	if _, ok := server.(*Plex); ok {
+		return "plex-recently-added-scan"
+	}
+	return "jellyfin-recently-added-sync"
 }
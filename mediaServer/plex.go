@@ -0,0 +1,155 @@
+package mediaServer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Plex media server.
+type Plex struct {
+	ApiURL string
+	Token  string
+}
+
+type plexSection struct {
+	Key        string `json:"key"`
+	Title      string `json:"title"`
+	Refreshing bool   `json:"refreshing"`
+	Location   []struct {
+		Path string `json:"path"`
+	} `json:"Location"`
+}
+
+func (p *Plex) Name() string {
+	return "Plex"
+}
+
+// Plex has no endpoint to refresh everything, so every library section is refreshed.
+func (p *Plex) Refresh() error {
+	sections, err := p.sections()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sections {
+		err = p.get("/library/sections/"+s.Key+"/refresh", nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Partial scan of the library sections that contain the paths. Paths in the same section are refreshed with one scan of their common directory.
+func (p *Plex) RefreshPaths(paths []string) error {
+	sections, err := p.sections()
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	sectionPaths := make(map[string][]string)
+	for _, path := range paths {
+		key := ""
+		for _, s := range sections {
+			for _, l := range s.Location {
+				if isSubPath(l.Path, path) {
+					key = s.Key
+				}
+			}
+		}
+		if key == "" {
+			return errors.New("no plex library contains " + path)
+		}
+
+		if _, ok := sectionPaths[key]; !ok {
+			keys = append(keys, key)
+		}
+		sectionPaths[key] = append(sectionPaths[key], path)
+	}
+
+	for _, key := range keys {
+		err = p.get("/library/sections/"+key+"/refresh?path="+url.QueryEscape(commonDir(sectionPaths[key])), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Plex) WaitForScan(ctx context.Context) error {
+	for {
+		sections, err := p.sections()
+		if err != nil {
+			return err
+		}
+
+		scanning := false
+		for _, s := range sections {
+			if s.Refreshing {
+				scanning = true
+			}
+		}
+		if !scanning {
+			return nil
+		}
+
+		log.Printf("Plex is performing a library scan. Checking again in %v", scanPollInterval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scanPollInterval):
+		}
+	}
+}
+
+func (p *Plex) sections() ([]plexSection, error) {
+	type SectionsResponseBody struct {
+		MediaContainer struct {
+			Directory []plexSection `json:"Directory"`
+		} `json:"MediaContainer"`
+	}
+
+	var body SectionsResponseBody
+	err := p.get("/library/sections", &body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.MediaContainer.Directory, nil
+}
+
+// Send a GET request to plex and decode the response into v, if not nil.
+func (p *Plex) get(path string, v interface{}) error {
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", p.ApiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Token", p.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("plex GET %v returned: %v", req.URL.Path, resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
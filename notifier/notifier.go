@@ -0,0 +1,163 @@
+package notifier
+
+import (
+	"bytes"
+	"debridGo/types"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Job events that can be notified.
+const (
+	Grabbed    = "grabbed"   // Sonarr/radarr grabbed a release.
+	Cached     = "cached"    // The torrent was already cached in Real-Debrid.
+	NotCached  = "notcached" // Real-Debrid has to download the torrent first.
+	Downloaded = "downloaded"
+	Progress   = "progress" // Progress of the conversion of a file. Only sent when the config lists it.
+	Converted  = "converted"
+	Uploaded   = "uploaded"
+	Failed     = "failed"
+)
+
+// Message templates used when the config doesn't set one for the event.
+var defaultTemplates = map[string]string{
+	Grabbed:    "Grabbed {{.Title}}{{if .Size}} ({{.HumanSize}}){{end}}",
+	Cached:     "{{.Title}} is cached in Real-Debrid",
+	NotCached:  "{{.Title}} is not cached in Real-Debrid, waiting for it to download",
+	Downloaded: "Downloaded {{.Title}}{{if .Size}} ({{.HumanSize}}){{end}}{{if .Duration}} in {{.Duration}}{{end}}",
+	Progress:   "Converting {{.Title}}: {{printf \"%.0f\" .Percent}}%{{if .ETA}}, {{.ETA}} left{{end}}",
+	Converted:  "Converted {{.Title}}{{if .Duration}} in {{.Duration}}{{end}}",
+	Uploaded:   "Uploaded {{.Title}}{{if .Size}} ({{.HumanSize}}){{end}}{{if .Duration}} in {{.Duration}}{{end}}",
+	Failed:     "{{.Title}} failed: {{.Error}}",
+}
+
+// Something that happened to a job.
+type Event struct {
+	Type     string
+	Title    string
+	Size     int64 // Bytes.
+	Duration time.Duration
+	Percent  float64       // Progress of a conversion, 0 to 100.
+	ETA      time.Duration // Time left of a conversion. 0 when unknown.
+	Err      error
+}
+
+func (e Event) HumanSize() string {
+	const unit = 1024
+	if e.Size < unit {
+		return fmt.Sprintf("%d B", e.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := e.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(e.Size)/float64(div), "KMGTPE"[exp])
+}
+
+func (e Event) Error() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+// Service that notifications are sent to.
+type Provider interface {
+	Name() string
+	Send(title, message string, e Event) error
+}
+
+// Sends the events enabled in the config to every configured provider.
+type Notifier struct {
+	providers []Provider
+	events    []string
+	templates map[string]*template.Template
+}
+
+// Notifier for the notify section of the config. Without providers the notifier does nothing.
+func New(conf types.TomlConfig) (*Notifier, error) {
+	n := &Notifier{
+		events:    conf.Notify.Events,
+		templates: make(map[string]*template.Template),
+	}
+
+	for _, p := range conf.Notify.Providers {
+		var provider Provider
+		switch strings.ToLower(p.Type) {
+		case "discord":
+			provider = &Discord{WebhookURL: p.URL}
+		case "telegram":
+			provider = &Telegram{Token: p.Token, ChatID: p.ChatID}
+		case "ntfy":
+			provider = &Ntfy{URL: p.URL, Token: p.Token}
+		case "gotify":
+			provider = &Gotify{URL: p.URL, Token: p.Token}
+		case "webhook":
+			provider = &Webhook{URL: p.URL}
+		default:
+			return nil, errors.New("unknown notification provider: " + p.Type)
+		}
+		n.providers = append(n.providers, provider)
+	}
+
+	for event, text := range defaultTemplates {
+		if custom, ok := conf.Notify.Templates[event]; ok {
+			text = custom
+		}
+
+		t, err := template.New(event).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v notification template: %w", event, err)
+		}
+		n.templates[event] = t
+	}
+
+	return n, nil
+}
+
+// Send the event to every provider. Errors are logged, a failing notification never stops a job.
+func (n *Notifier) Notify(e Event) {
+	if n == nil || len(n.providers) == 0 || !n.enabled(e.Type) {
+		return
+	}
+
+	t, ok := n.templates[e.Type]
+	if !ok {
+		log.Println("No notification template for event: ", e.Type)
+		return
+	}
+
+	var message bytes.Buffer
+	err := t.Execute(&message, e)
+	if err != nil {
+		log.Printf("Could not build %v notification: %v", e.Type, err)
+		return
+	}
+
+	title := "debridGo: " + e.Type
+	for _, p := range n.providers {
+		err = p.Send(title, message.String(), e)
+		if err != nil {
+			log.Printf("Could not send %v notification: %v", p.Name(), err)
+		}
+	}
+}
+
+// Whether the event is enabled in the config. All events but progress, sent several times per file, are enabled if
+// none is set.
+func (n *Notifier) enabled(event string) bool {
+	if len(n.events) == 0 {
+		return event != Progress
+	}
+	for _, e := range n.events {
+		if strings.EqualFold(e, event) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Max time to wait for a notification service to answer.
+const sendTimeout = 10 * time.Second
+
+// Discord channel webhook.
+type Discord struct {
+	WebhookURL string
+}
+
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+func (d *Discord) Send(title, message string, e Event) error {
+	type Embed struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Color       int    `json:"color"`
+	}
+	type Body struct {
+		Embeds []Embed `json:"embeds"`
+	}
+
+	color := 0x2ecc71
+	if e.Type == Failed {
+		color = 0xe74c3c
+	}
+
+	return postJSON(d.WebhookURL, nil, Body{Embeds: []Embed{{Title: title, Description: message, Color: color}}})
+}
+
+// Telegram bot sending messages to a chat.
+type Telegram struct {
+	Token  string
+	ChatID string
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Send(title, message string, e Event) error {
+	type Body struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}
+
+	return postJSON("https://api.telegram.org/bot"+t.Token+"/sendMessage", nil, Body{ChatID: t.ChatID, Text: title + "\n" + message})
+}
+
+// ntfy topic. URL includes the topic, e.g. https://ntfy.sh/debridgo.
+type Ntfy struct {
+	URL   string
+	Token string
+}
+
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+func (n *Ntfy) Send(title, message string, e Event) error {
+	headers := map[string]string{"Title": title}
+	if n.Token != "" {
+		headers["Authorization"] = "Bearer " + n.Token
+	}
+	if e.Type == Failed {
+		headers["Priority"] = "high"
+		headers["Tags"] = "warning"
+	}
+
+	return post(n.URL, headers, "text/plain", bytes.NewBufferString(message))
+}
+
+// Gotify server. Token is the token of the gotify application.
+type Gotify struct {
+	URL   string
+	Token string
+}
+
+func (g *Gotify) Name() string {
+	return "gotify"
+}
+
+func (g *Gotify) Send(title, message string, e Event) error {
+	type Body struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+
+	priority := 5
+	if e.Type == Failed {
+		priority = 8
+	}
+
+	return postJSON(g.URL+"/message", map[string]string{"X-Gotify-Key": g.Token}, Body{Title: title, Message: message, Priority: priority})
+}
+
+// Generic webhook receiving the event as JSON.
+type Webhook struct {
+	URL string
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Send(title, message string, e Event) error {
+	type Body struct {
+		Event    string  `json:"event"`
+		Title    string  `json:"title"`
+		Message  string  `json:"message"`
+		Job      string  `json:"job"`
+		Size     int64   `json:"size,omitempty"`
+		Duration float64 `json:"duration,omitempty"` // Seconds.
+		Percent  float64 `json:"percent,omitempty"`
+		Error    string  `json:"error,omitempty"`
+	}
+
+	return postJSON(w.URL, nil, Body{
+		Event:    e.Type,
+		Title:    title,
+		Message:  message,
+		Job:      e.Title,
+		Size:     e.Size,
+		Duration: e.Duration.Seconds(),
+		Percent:  e.Percent,
+		Error:    e.Error(),
+	})
+}
+
+func postJSON(url string, headers map[string]string, body interface{}) error {
+	postBodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return post(url, headers, "application/json", bytes.NewBuffer(postBodyJSON))
+}
+
+func post(endpoint string, headers map[string]string, contentType string, body io.Reader) error {
+	client := &http.Client{Timeout: sendTimeout}
+	req, err := http.NewRequest("POST", endpoint, body)
+	if err != nil {
+		return errors.New("invalid notification URL")
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	// The URL isn't part of the error, it has the telegram bot token or the discord webhook secret.
+	resp, err := client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("POST failed: %w", urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST returned: %v", resp.Status)
+	}
+
+	return nil
+}
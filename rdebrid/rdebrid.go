@@ -3,6 +3,7 @@ package rdebrid
 import (
 	"bufio"
 	"debridGo/config"
+	"debridGo/servarr"
 	"debridGo/types"
 	"encoding/json"
 	"errors"
@@ -125,7 +126,42 @@ func AddTorrent(releaseTitle string) (string, error) {
 	return torrentId, nil
 }
 
-func TorrentInfo(torrentId string, status bool) (types.TorrentInfoResponseBody, error) {
+// Error returned by WaitForDownload when Real-Debrid will not be able to download a torrent.
+type BadStatusError struct {
+	Status string
+}
+
+func (e *BadStatusError) Error() string {
+	return "received bad torrent status from Real-Debrid: " + e.Status
+}
+
+// Poll a torrent until Real-Debrid has downloaded it, or returns a BadStatusError if it won't be able to. Gives up with
+// an error after downloadTimeout.
+func WaitForDownload(torrentId string) (types.TorrentInfoResponseBody, error) {
+	deadline := time.Now().Add(downloadTimeout)
+	for {
+		torrent, err := TorrentInfo(torrentId)
+		if err != nil {
+			return torrent, err
+		}
+
+		switch torrent.Status {
+		case "magnet_error", "error", "virus", "dead":
+			return torrent, &BadStatusError{Status: torrent.Status}
+		case "magnet_conversion", "waiting_files_selection", "queued", "downloading", "uploading":
+			if time.Now().After(deadline) {
+				return torrent, fmt.Errorf("torrent %v is still %v in Real-Debrid after %v", torrentId, torrent.Status, downloadTimeout)
+			}
+			log.Println("File is not ready to download. Torrent status in Real-Debrid: ", torrent.Status)
+			time.Sleep(pollInterval)
+		default:
+			log.Println("Files are ready to download from Real Debrid.")
+			return torrent, nil
+		}
+	}
+}
+
+func TorrentInfo(torrentId string) (types.TorrentInfoResponseBody, error) {
 	time.Sleep(500 * time.Millisecond)
 
 	// Get values from configDebridGo.toml
@@ -161,29 +197,39 @@ func TorrentInfo(torrentId string, status bool) (types.TorrentInfoResponseBody,
 		return torrentInfoResponseBody, err
 	}
 
-	if status {
-		switch torrentInfoResponseBody.Status {
-		case "magnet_error", "error", "virus", "dead":
-			errStr := fmt.Sprintln("received bad torrent status from Real-Debrid: ", torrentInfoResponseBody.Status)
-			err := errors.New(errStr)
-			return torrentInfoResponseBody, err
-		case "magnet_conversion", "waiting_files_selection", "queued", "downloading", "uploading":
-			log.Println("File is not ready to download. Torrent status in Real-Debrid: ", torrentInfoResponseBody.Status)
-			time.Sleep(1000 * time.Millisecond)
-			resp.Body.Close()
-			client.CloseIdleConnections()
-			TorrentInfo(torrentId, true)
-			return torrentInfoResponseBody, nil
-		default:
-			log.Println("Files are ready to download from Real Debrid.")
-		}
-	}
-
 	resp.Body.Close()
 	client.CloseIdleConnections()
 
 	return torrentInfoResponseBody, nil
+}
+
+// Mark the release of the torrent as failed in sonarr/radarr, which blocklists it, and search again for the same episodes/movie.
+// The sonarr/radarr instance is taken from the data saved by the "On Grab" trigger for the torrent hash.
+func blocklist(hash string) error {
+	conf, err := config.Values()
+	if err != nil {
+		return err
+	}
+
+	jsonFile, err := os.ReadFile(conf.DebridGo.DownloadDir + "/" + strings.ToLower(hash) + ".json")
+	if err != nil {
+		return err
+	}
+
+	var data types.DataJSON
+	err = json.Unmarshal(jsonFile, &data)
+	if err != nil {
+		return err
+	}
+
+	switch data.Category {
+	case "tv-sonarr":
+		return servarr.MarkFailedAndSearch(data.TorrentHash, conf.Sonarr.ApiURL, conf.Sonarr.ApiKey)
+	case "radarr":
+		return servarr.MarkFailedAndSearch(data.TorrentHash, conf.Radarr.ApiURL, conf.Radarr.ApiKey)
+	}
 
+	return errors.New("unknown category: " + data.Category)
 }
 
 func SelectAndDownload(addedTorrentId string) error {
@@ -196,7 +242,7 @@ func SelectAndDownload(addedTorrentId string) error {
 	RDapiKey := conf.DebridGo.RDapiKey
 
 	// Get torrent information to select required files from it
-	torrent, err := TorrentInfo(addedTorrentId, false)
+	torrent, err := TorrentInfo(addedTorrentId)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,114 @@
+package rdebrid
+
+import (
+	"debridGo/config"
+	"debridGo/notifier"
+	"debridGo/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	pollInterval    = 30 * time.Second // Interval between checks of a torrent in Real-Debrid.
+	findTimeout     = 10 * time.Minute // Max time to wait for the download client to add a grabbed torrent to Real-Debrid.
+	downloadTimeout = 24 * time.Hour   // Max time to wait for Real-Debrid to download a torrent.
+)
+
+// Follow a torrent grabbed by sonarr/radarr, and added to Real-Debrid by the download client, until Real-Debrid has
+// downloaded it. Whether the torrent was cached is notified with title. A torrent Real-Debrid can't download is
+// blocklisted in sonarr/radarr, which search again for the same episodes/movie.
+func Watch(hash, title string, notify *notifier.Notifier) error {
+	torrent, err := findTorrent(hash)
+	if err != nil {
+		return err
+	}
+
+	if title == "" {
+		title = torrent.Filename
+	}
+	notifyCached(notify, title, torrent.Status)
+
+	torrent, err = WaitForDownload(torrent.Id)
+	var badStatus *BadStatusError
+	if errors.As(err, &badStatus) {
+		// Let sonarr/radarr know the release is no good so they can grab another one.
+		blErr := blocklist(hash)
+		if blErr != nil {
+			log.Println("Could not blocklist release: ", blErr)
+		}
+	}
+
+	return err
+}
+
+// The status of the first check of a torrent tells whether it was already cached in Real-Debrid.
+func notifyCached(notify *notifier.Notifier, title, status string) {
+	switch status {
+	case "downloaded":
+		notify.Notify(notifier.Event{Type: notifier.Cached, Title: title})
+	case "magnet_conversion", "waiting_files_selection", "queued", "downloading":
+		notify.Notify(notifier.Event{Type: notifier.NotCached, Title: title})
+	}
+}
+
+// Poll the torrents of the Real-Debrid account until the one with hash shows up.
+func findTorrent(hash string) (types.TorrentInfoResponseBody, error) {
+	deadline := time.Now().Add(findTimeout)
+	for {
+		torrents, err := torrents()
+		if err != nil {
+			return types.TorrentInfoResponseBody{}, err
+		}
+		for _, t := range torrents {
+			if strings.EqualFold(t.Hash, hash) {
+				return t, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return types.TorrentInfoResponseBody{}, fmt.Errorf("torrent %v not found in Real-Debrid after %v", hash, findTimeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Latest torrents of the Real-Debrid account.
+func torrents() ([]types.TorrentInfoResponseBody, error) {
+	conf, err := config.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", "https://api.real-debrid.com/rest/1.0/torrents?limit=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.DebridGo.RDapiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Real-Debrid GET /torrents returned: %v", resp.Status)
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var torrents []types.TorrentInfoResponseBody
+	err = json.NewDecoder(resp.Body).Decode(&torrents)
+	if err != nil {
+		return nil, err
+	}
+
+	return torrents, nil
+}
@@ -0,0 +1,189 @@
+package servarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	commandPollInterval = 5 * time.Second  // Interval between status checks of a sonarr/radarr command.
+	commandTimeout      = 10 * time.Minute // Max time to wait for a rescan command to finish.
+)
+
+// Command as returned by the sonarr/radarr /command endpoint.
+type Command struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Body    struct {
+		SeriesId     int   `json:"seriesId"`
+		SeasonNumber int   `json:"seasonNumber"`
+		EpisodeIds   []int `json:"episodeIds"`
+	} `json:"body"`
+}
+
+// Terminal reports whether the command will not change its status anymore.
+func (c Command) Terminal() bool {
+	switch c.Status {
+	case "completed", "failed", "aborted", "cancelled", "orphaned":
+		return true
+	}
+	return false
+}
+
+// WaitForCommand polls a sonarr/radarr command until it reaches a terminal state, the timeout expires or ctx is done.
+// The last known state of the command is always returned. A command that did not complete successfully returns an error with its status and message.
+func WaitForCommand(ctx context.Context, apiURL, apiKey string, id int, timeout time.Duration) (Command, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(commandPollInterval)
+	defer ticker.Stop()
+
+	var command Command
+	for {
+		c, err := getCommand(ctx, apiURL, apiKey, id)
+		if err != nil && ctx.Err() == nil {
+			return command, err
+		}
+		if err == nil {
+			command = c
+		}
+
+		if command.Terminal() {
+			if command.Status != "completed" {
+				return command, fmt.Errorf("command %v (%v) %v: %v", command.Name, command.Id, command.Status, command.Message)
+			}
+			return command, nil
+		}
+
+		log.Printf("Command %v (%v) status: %v. Checking again in %v.", command.Name, id, command.Status, commandPollInterval)
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return command, fmt.Errorf("command %v (%v) did not finish within %v, last status: %v", command.Name, id, timeout, command.Status)
+			}
+			return command, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func getCommand(ctx context.Context, apiURL, apiKey string, id int) (Command, error) {
+	var command Command
+
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL+"/command/"+strconv.Itoa(id)+"?apikey="+apiKey, nil)
+	if err != nil {
+		return command, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return command, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return command, fmt.Errorf("command %v status request returned: %v", id, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&command)
+	if err != nil {
+		return command, err
+	}
+
+	return command, nil
+}
+
+// Stop the queued sonarr searches of a series for the grabbed episodeIds: EpisodeSearch commands whose episodes are all in
+// episodeIds, and SeasonSearch and SeriesSearch commands whose season or series episodes are all in episodeIds, as with
+// a season pack. Without episodeIds every search of the series is stopped. With dryRun nothing is stopped.
+// Returns the commands that were (or would have been) stopped.
+func StopEpisodeSearch(seriesSonarrId int, episodeIds []int, dryRun bool, apiURL, apiKey string) ([]Command, error) {
+	var commands []Command
+	err := getJSON(apiURL+"/command?apikey="+apiKey, &commands)
+	if err != nil {
+		return nil, err
+	}
+
+	var episodes []Episode
+	err = getJSON(apiURL+"/episode?seriesId="+strconv.Itoa(seriesSonarrId)+"&apikey="+apiKey, &episodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Episode ids of the series by season. Without episode ids, any episode of the series counts.
+	seasons := make(map[int][]int)
+	var seriesIds []int
+	for _, e := range episodes {
+		seasons[e.SeasonNumber] = append(seasons[e.SeasonNumber], e.Id)
+		seriesIds = append(seriesIds, e.Id)
+	}
+	targetIds := episodeIds
+	if len(targetIds) == 0 {
+		targetIds = seriesIds
+	}
+
+	var stopped []Command
+	for _, c := range commands {
+		// Started commands can't be cancelled.
+		if c.Status != "queued" {
+			continue
+		}
+
+		switch c.Name {
+		case "EpisodeSearch":
+			if len(c.Body.EpisodeIds) == 0 || !containsAllInt(targetIds, c.Body.EpisodeIds) {
+				continue
+			}
+		case "SeasonSearch":
+			season := seasons[c.Body.SeasonNumber]
+			if c.Body.SeriesId != seriesSonarrId || len(season) == 0 || !containsAllInt(targetIds, season) {
+				continue
+			}
+		case "SeriesSearch":
+			if c.Body.SeriesId != seriesSonarrId || len(seriesIds) == 0 || !containsAllInt(targetIds, seriesIds) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if dryRun {
+			log.Printf("Dry run. Would stop command %v (%v) for episodes %v", c.Name, c.Id, c.Body.EpisodeIds)
+			stopped = append(stopped, c)
+			continue
+		}
+
+		log.Printf("Stopping command %v (%v) for episodes %v", c.Name, c.Id, c.Body.EpisodeIds)
+		client := &http.Client{}
+		req, err := http.NewRequest("DELETE", apiURL+"/command/"+strconv.Itoa(c.Id)+"?apikey="+apiKey, nil)
+		if err != nil {
+			return stopped, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return stopped, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			log.Printf("Delete request to stop %v (%v) returned: %v", c.Name, c.Id, resp.Status)
+			continue
+		}
+		stopped = append(stopped, c)
+	}
+
+	return stopped, nil
+}
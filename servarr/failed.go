@@ -0,0 +1,167 @@
+package servarr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// History record as returned by the sonarr/radarr /history endpoint.
+type HistoryRecord struct {
+	Id         int    `json:"id"`
+	EventType  string `json:"eventType"`
+	DownloadId string `json:"downloadId"`
+	EpisodeId  int    `json:"episodeId"` // Sonarr only.
+	MovieId    int    `json:"movieId"`   // Radarr only.
+}
+
+// Mark the release grabbed with downloadId as failed so sonarr/radarr blocklist it, and search again for the episodes or movie it was grabbed for.
+func MarkFailedAndSearch(downloadId, apiURL, apiKey string) error {
+	records, err := grabbedHistory(downloadId, apiURL, apiKey)
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		// Marking one grab as failed blocklists the whole release.
+		log.Printf("Marking release %v as failed.", downloadId)
+		err = post(apiURL+"/history/failed/"+strconv.Itoa(records[0].Id)+"?apikey="+apiKey, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		// No grab in the history, fall back to removing it from the queue with blocklist. Its queue items have the
+		// episodes or movie to search again.
+		records, err = deleteFromQueue(downloadId, apiURL, apiKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	var episodeIds, movieIds []int
+	for _, r := range records {
+		if r.EpisodeId > 0 && !containsInt(episodeIds, r.EpisodeId) {
+			episodeIds = append(episodeIds, r.EpisodeId)
+		}
+		if r.MovieId > 0 && !containsInt(movieIds, r.MovieId) {
+			movieIds = append(movieIds, r.MovieId)
+		}
+	}
+
+	if len(episodeIds) > 0 {
+		log.Printf("Searching again for episodes: %v", episodeIds)
+		return post(apiURL+"/command?apikey="+apiKey, map[string]interface{}{"name": "EpisodeSearch", "episodeIds": episodeIds})
+	}
+	if len(movieIds) > 0 {
+		log.Printf("Searching again for movies: %v", movieIds)
+		return post(apiURL+"/command?apikey="+apiKey, map[string]interface{}{"name": "MoviesSearch", "movieIds": movieIds})
+	}
+
+	return errors.New("no episodes or movies found to search again for release " + downloadId)
+}
+
+func grabbedHistory(downloadId, apiURL, apiKey string) ([]HistoryRecord, error) {
+	type HistoryResponseBody struct {
+		Records []HistoryRecord `json:"records"`
+	}
+
+	var history HistoryResponseBody
+	err := getJSON(apiURL+"/history?pageSize=100&downloadId="+url.QueryEscape(downloadId)+"&apikey="+apiKey, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	var grabbed []HistoryRecord
+	for _, r := range history.Records {
+		if r.EventType == "grabbed" && r.DownloadId == downloadId {
+			grabbed = append(grabbed, r)
+		}
+	}
+
+	return grabbed, nil
+}
+
+// Remove a release from the queue, removing it from the download client and adding it to the blocklist. Returns its
+// queue items, one per episode for sonarr, as records with the episode or movie ids.
+func deleteFromQueue(downloadId, apiURL, apiKey string) ([]HistoryRecord, error) {
+	type QueueItem struct {
+		Id         int    `json:"id"`
+		DownloadId string `json:"downloadId"`
+		EpisodeId  int    `json:"episodeId"` // Sonarr only.
+		MovieId    int    `json:"movieId"`   // Radarr only.
+	}
+	type QueueResponseBody struct {
+		Records []QueueItem `json:"records"`
+	}
+
+	var queue QueueResponseBody
+	err := getJSON(apiURL+"/queue?pageSize=1000&apikey="+apiKey, &queue)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []QueueItem
+	var records []HistoryRecord
+	for _, q := range queue.Records {
+		if q.DownloadId == downloadId {
+			items = append(items, q)
+			records = append(records, HistoryRecord{DownloadId: q.DownloadId, EpisodeId: q.EpisodeId, MovieId: q.MovieId})
+		}
+	}
+	if len(items) == 0 {
+		return nil, errors.New("release " + downloadId + " not found in history nor queue")
+	}
+
+	// Removing one item removes the whole release from the download client.
+	log.Printf("Removing release %v from queue and adding it to the blocklist.", downloadId)
+	client := &http.Client{}
+	req, err := http.NewRequest("DELETE", apiURL+"/queue/"+strconv.Itoa(items[0].Id)+"?removeFromClient=true&blocklist=true&apikey="+apiKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("queue delete returned: %v", resp.Status)
+	}
+	return records, nil
+}
+
+func post(endpoint string, body interface{}) error {
+	var postBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&postBody).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest("POST", endpoint, &postBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %v returned: %v", req.URL.Path, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package servarr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Episode as returned by the sonarr /episode endpoint.
+type Episode struct {
+	Id            int  `json:"id"`
+	SeriesId      int  `json:"seriesId"`
+	EpisodeFileId int  `json:"episodeFileId"`
+	SeasonNumber  int  `json:"seasonNumber"`
+	EpisodeNumber int  `json:"episodeNumber"`
+	HasFile       bool `json:"hasFile"`
+}
+
+// Get the id and path of the file radarr has imported for a movie.
+func MovieFile(movieRadarrId int, radarrApiUrl, radarrApiKey string) (int, string, error) {
+	type MovieResponseBody struct {
+		HasFile   bool `json:"hasFile"`
+		MovieFile struct {
+			Id   int    `json:"id"`
+			Path string `json:"path"`
+		} `json:"movieFile"`
+	}
+
+	var movie MovieResponseBody
+	err := getJSON(radarrApiUrl+"/movie/"+strconv.Itoa(movieRadarrId)+"?apikey="+radarrApiKey, &movie)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if !movie.HasFile || movie.MovieFile.Id == 0 {
+		return 0, "", fmt.Errorf("radarr has no file for movie %v", movieRadarrId)
+	}
+
+	return movie.MovieFile.Id, movie.MovieFile.Path, nil
+}
+
+// Get the paths of the episode files of a series in sonarr, by episode file id.
+func EpisodeFilePaths(seriesSonarrId int, sonarrApiUrl, sonarrApiKey string) (map[int]string, error) {
+	type EpisodeFile struct {
+		Id   int    `json:"id"`
+		Path string `json:"path"`
+	}
+
+	var files []EpisodeFile
+	err := getJSON(sonarrApiUrl+"/episodefile?seriesId="+strconv.Itoa(seriesSonarrId)+"&apikey="+sonarrApiKey, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[int]string)
+	for _, f := range files {
+		paths[f.Id] = f.Path
+	}
+	return paths, nil
+}
+
+// Get the episodes of a season in sonarr. If episodeNumbers is not empty only those episodes are returned.
+func Episodes(seriesSonarrId, seasonNumber int, episodeNumbers []int, sonarrApiUrl, sonarrApiKey string) ([]Episode, error) {
+	var episodes []Episode
+	err := getJSON(sonarrApiUrl+"/episode?seriesId="+strconv.Itoa(seriesSonarrId)+"&apikey="+sonarrApiKey, &episodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var season []Episode
+	for _, e := range episodes {
+		if e.SeasonNumber != seasonNumber {
+			continue
+		}
+		if len(episodeNumbers) > 0 && !containsInt(episodeNumbers, e.EpisodeNumber) {
+			continue
+		}
+		season = append(season, e)
+	}
+
+	return season, nil
+}
+
+// Get the episodes of a season that have a file in sonarr. If episodeNumbers is not empty only those episodes are returned.
+func EpisodesWithFile(seriesSonarrId, seasonNumber int, episodeNumbers []int, sonarrApiUrl, sonarrApiKey string) ([]Episode, error) {
+	episodes, err := Episodes(seriesSonarrId, seasonNumber, episodeNumbers, sonarrApiUrl, sonarrApiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var withFile []Episode
+	for _, e := range episodes {
+		if e.HasFile && e.EpisodeFileId != 0 {
+			withFile = append(withFile, e)
+		}
+	}
+
+	if len(withFile) == 0 {
+		return nil, errors.New("sonarr has no episode files for season " + strconv.Itoa(seasonNumber) + " of series " + strconv.Itoa(seriesSonarrId))
+	}
+
+	return withFile, nil
+}
+
+// Whether every monitored season of a series has a file for all its monitored aired episodes in sonarr. A series
+// without monitored seasons isn't complete.
+func SeriesComplete(seriesSonarrId int, sonarrApiUrl, sonarrApiKey string) (bool, error) {
+	type SeriesResponseBody struct {
+		Seasons []struct {
+			Monitored  bool `json:"monitored"`
+			Statistics struct {
+				EpisodeFileCount int `json:"episodeFileCount"`
+				EpisodeCount     int `json:"episodeCount"` // Monitored episodes that aired or have a file.
+			} `json:"statistics"`
+		} `json:"seasons"`
+	}
+
+	var series SeriesResponseBody
+	err := getJSON(sonarrApiUrl+"/series/"+strconv.Itoa(seriesSonarrId)+"?apikey="+sonarrApiKey, &series)
+	if err != nil {
+		return false, err
+	}
+
+	monitored := 0
+	for _, s := range series.Seasons {
+		if !s.Monitored {
+			continue
+		}
+		monitored++
+		if s.Statistics.EpisodeFileCount < s.Statistics.EpisodeCount {
+			return false, nil
+		}
+	}
+	return monitored > 0, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v returned: %v", req.URL.Path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func containsInt(s []int, v int) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAllInt(s []int, values []int) bool {
+	for _, v := range values {
+		if !containsInt(s, v) {
+			return false
+		}
+	}
+	return true
+}
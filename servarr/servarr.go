@@ -3,8 +3,8 @@ package servarr
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"io/fs"
 	"os"
@@ -13,14 +13,14 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 )
 
-// Copy to destination using rclone.
-func CopyToDst(saveDir, rcloneDstDir string) error {
-	err := removeUnwanted(saveDir)
+// Copy the media files of saveDir, and their subtitles, to destination using rclone. Everything else in saveDir is
+// removed.
+func CopyToDst(saveDir, rcloneDstDir string, media []string) error {
+	err := removeUnwanted(saveDir, media)
 	if err != nil {
 		return err
 	}
@@ -117,8 +117,7 @@ func RescanSonarr(seriesSonarrId int, sonarrApiUrl, sonarrApiKey string) error {
 		return err
 	}
 
-	time.Sleep(5000 * time.Millisecond)
-	err = checkSonarrRescanStatus(sonarrRescanBody.CommandId, sonarrApiUrl, sonarrApiKey)
+	_, err = WaitForCommand(context.Background(), sonarrApiUrl, sonarrApiKey, sonarrRescanBody.CommandId, commandTimeout)
 	if err != nil {
 		return err
 	}
@@ -126,54 +125,7 @@ func RescanSonarr(seriesSonarrId int, sonarrApiUrl, sonarrApiKey string) error {
 	return nil
 }
 
-func checkSonarrRescanStatus(commandId int, sonarrApiUrl, sonarrApiKey string) error {
-	log.Println("Checking recent Sonarr rescan status.")
-	client := &http.Client{}
-	commandIdStr := strconv.Itoa(commandId)
-	req, err := http.NewRequest("GET", sonarrApiUrl+"/command/"+commandIdStr+"/"+"?apikey="+sonarrApiKey, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	bodyResp, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	// Get the id of the recently executed command and check its status
-	type SonarrRescanCheckStatusResponseBody struct {
-		Status string `json:"status"`
-	}
-	var sonarrRescanCheckStatusBody SonarrRescanCheckStatusResponseBody
-	err = json.Unmarshal(bodyResp, &sonarrRescanCheckStatusBody)
-	if err != nil {
-		return err
-	}
-
-	if sonarrRescanCheckStatusBody.Status == "failed" {
-		err = errors.New("sonarr rescan failed")
-		return err
-	}
-
-	if sonarrRescanCheckStatusBody.Status != "completed" {
-		log.Printf("Rescan status: %v", sonarrRescanCheckStatusBody.Status)
-		log.Println("Rescan not completed. Checking again in 5s.")
-		time.Sleep(5000 * time.Millisecond)
-		checkSonarrRescanStatus(commandId, sonarrApiUrl, sonarrApiKey)
-		return nil
-	}
-	return nil
-}
-
-func RescanRadarr(movieRadarrId int, radarrApiUrl, radarrApiKey, bazarrApiURL, bazarrApiKey string) error {
+func RescanRadarr(movieRadarrId int, radarrApiUrl, radarrApiKey string) error {
 	log.Println("Refreshing movie in radarr")
 	client := &http.Client{}
 
@@ -221,157 +173,72 @@ func RescanRadarr(movieRadarrId int, radarrApiUrl, radarrApiKey, bazarrApiURL, b
 		return err
 	}
 
-	time.Sleep(5000 * time.Millisecond)
-	err = checkRadarrRescanStatus(radarrRescanBody.CommandId, radarrApiUrl, radarrApiKey)
+	_, err = WaitForCommand(context.Background(), radarrApiUrl, radarrApiKey, radarrRescanBody.CommandId, commandTimeout)
 	if err != nil {
 		return err
 	}
 
-	// Send req to bazarr to search for subtitles.
-
-	bazarrBodyData := strings.NewReader("radarr_moviefile_id=" + strconv.Itoa(movieRadarrId))
-	req, err = http.NewRequest("POST", bazarrApiURL+"/radarr", bazarrBodyData)
-	if err != nil {
-		return err
-
-	}
-	req.Header.Set("x-api-key", bazarrApiKey)
-	req.Header.Set("content-type", "application/x-www-form-urlencoded")
-	_, err = client.Do(req)
-	if err != nil {
-		return err
-	}
-	time.Sleep(5000 * time.Millisecond)
 	return nil
 }
 
-func checkRadarrRescanStatus(commandId int, radarrApiUrl, radarrApiKey string) error {
-	log.Println("Checking recent Radarr rescan status.")
-	client := &http.Client{}
-	commandIdStr := strconv.Itoa(commandId)
-	req, err := http.NewRequest("GET", radarrApiUrl+"/command/"+commandIdStr+"/"+"?apikey="+radarrApiKey, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+// Directory of the extras of a download, where Plex and Emby look for local extras.
+const ExtrasDir = "Featurettes"
 
-	defer resp.Body.Close()
-
-	bodyResp, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	// Get the id of the recently executed command and check its status
-	type RadarrRescanCheckStatusResponseBody struct {
-		Status string `json:"status"`
-	}
-	var radarrRescanCheckStatusBody RadarrRescanCheckStatusResponseBody
-	err = json.Unmarshal(bodyResp, &radarrRescanCheckStatusBody)
-	if err != nil {
-		return err
-	}
-
-	if radarrRescanCheckStatusBody.Status == "failed" {
-		err = errors.New("radarr rescan failed")
-		return err
-	}
-
-	if radarrRescanCheckStatusBody.Status != "completed" {
-		log.Printf("Rescan status: %v", radarrRescanCheckStatusBody.Status)
-		log.Println("Rescan not completed. Checking again in 5s.")
-		time.Sleep(5000 * time.Millisecond)
-		checkRadarrRescanStatus(commandId, radarrApiUrl, radarrApiKey)
-		return nil
-	}
-
-	return nil
+// Subtitles uploaded next to their video: extracted from it or imported from the download.
+var subtitleExtensions = map[string]bool{
+	".vtt": true,
+	".srt": true,
+	".ass": true,
+	".ssa": true,
 }
 
-func StopEpisodeSearch(releaseTitle, apiURL, apiKey string) error {
-	type Body struct {
-		Name    string `json:"name"`
-		Message string `json:"message"`
-		Status  string `json:"status"`
-		Id      int    `json:"id"`
+// Remove every file of saveDir that isn't one of the media files or one of their subtitles, which are next to them
+// and named after them, and then the directories left empty.
+func removeUnwanted(saveDir string, media []string) error {
+	kept := make(map[string]bool)
+	for _, m := range media {
+		kept[filepath.Clean(m)] = true
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", apiURL+"/command/?apikey="+apiKey, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+	var dirs []string
+	err := filepath.WalkDir(saveDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-	defer resp.Body.Close()
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
 
-	var body []Body
-	err = json.NewDecoder(resp.Body).Decode(&body)
+		path = filepath.Clean(path)
+		if kept[path] || isSubtitleOf(path, kept) {
+			return nil
+		}
+		return os.Remove(path)
+	})
 	if err != nil {
 		return err
 	}
 
-	resp.Body.Close()
-
-	// Stop and remove all the episode search tasks.
-	var commandIds []int
-
-	for _, task := range body {
-		if task.Name == "EpisodeSearch" {
-			commandIds = append(commandIds, task.Id)
-		}
-	}
-	if len(commandIds) > 0 {
-		for _, id := range commandIds {
-			log.Println(`Stopping command "EpisodeSearch" with id: ` + strconv.Itoa(id))
-			req, err := http.NewRequest("DELETE", apiURL+"/command/"+strconv.Itoa(id)+"?apikey="+apiKey, nil)
-			if err != nil {
-				return err
-			}
-
-			resp, err := client.Do(req)
-			if err != nil {
-				return err
-			}
-
-			log.Println("Delete request to stop EpisodeSearch returned: " + resp.Status)
-
-		}
+	// Subdirectories come after their parent, so they are removed first. dirs[0] is saveDir, which is kept.
+	for i := len(dirs) - 1; i > 0; i-- {
+		os.Remove(dirs[i])
 	}
 
 	return nil
 }
 
-func removeUnwanted(saveDir string) error {
-
-	filepath.WalkDir(saveDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip and remove subdirectories that usually have subs or other unwanted files.
-		if d.IsDir() && d.Name() != filepath.Base(saveDir) {
-			os.RemoveAll(saveDir + "/" + d.Name())
-			return filepath.SkipDir
-		}
+// Whether path is a subtitle of one of the media files.
+func isSubtitleOf(path string, media map[string]bool) bool {
+	if !subtitleExtensions[strings.ToLower(filepath.Ext(path))] {
+		return false
+	}
 
-		// Remove unwanted files.
-		if !d.IsDir() && !strings.HasSuffix(d.Name(), ".mp4") && !strings.HasSuffix(d.Name(), ".vtt") {
-			os.Remove(saveDir + "/" + d.Name())
+	for m := range media {
+		if filepath.Dir(m) == filepath.Dir(path) && strings.HasPrefix(path, strings.TrimSuffix(m, filepath.Ext(m))+".") {
+			return true
 		}
-
-		return nil
-	})
-
-	return nil
+	}
+	return false
 }
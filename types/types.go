@@ -7,9 +7,11 @@ type debridGo struct {
 }
 
 type sonarr struct {
-	ApiURL    string
-	ApiKey    string
-	SeriesDir string
+	ApiURL                string
+	ApiKey                string
+	SeriesDir             string
+	StopDuplicateSearches bool // Stop queued searches for the episodes of a release once it is grabbed.
+	StopSearchesDryRun    bool // Only log the searches that would be stopped.
 }
 
 type radarr struct {
@@ -24,8 +26,10 @@ type bazarr struct {
 }
 
 type jellyseerr struct {
-	ApiURL string
-	ApiKey string
+	ApiURL        string
+	ApiKey        string
+	MarkAvailable bool // Mark the request of the downloaded media as available, which notifies the user that requested it.
+	CommentIssues bool // Comment on the open issues of the downloaded media.
 }
 
 type rclone struct {
@@ -39,27 +43,149 @@ type emby struct {
 	ApiKey string
 }
 
+type jellyfin struct {
+	ApiURL string
+	ApiKey string
+}
+
+type plex struct {
+	ApiURL string
+	Token  string
+}
+
+type pathMapping struct {
+	Rclone string // rclone destination, e.g. "gdrive:Series".
+	Local  string // Same directory as seen by the media server, e.g. "/mnt/media/Series".
+}
+
+type mediaServer struct {
+	Type      string // emby, jellyfin or plex. Defaults to emby.
+	ScanDelay int    // Seconds to wait after the upload before refreshing, so the media server can see the new files. Defaults to 60.
+	// Scans requested by other jobs during ScanDelay are batched with it.
+	PathMappings []pathMapping
+}
+
+type notifyProvider struct {
+	Type   string // discord, telegram, ntfy, gotify or webhook.
+	URL    string // Webhook URL for discord/webhook, server URL for gotify and topic URL for ntfy.
+	Token  string // Bot token for telegram, application token for gotify and access token for ntfy.
+	ChatID string // Telegram only.
+}
+
+type notify struct {
+	Events    []string          // Events to notify. All of them but progress if empty.
+	Templates map[string]string // Go templates of the messages by event.
+	Providers []notifyProvider
+}
+
+// Target of the video conversion.
+type ConversionProfile struct {
+	Container     string // Output container: mp4 or mkv.
+	AudioCodec    string // Codec the default audio track must have, e.g. aac.
+	AudioChannels int    // Channels the default audio track must have, e.g. 2.
+	Downmix       string // How a created audio track is downmixed: standard, nightmode (dialogue boost) or loudnorm (EBU R128). Defaults to standard.
+	// Audio languages to keep, by preference, e.g. ["original", "en", "es"]. "original" is the language of the first
+	// audio track. The compatible track is created from, or chosen among, the tracks of the most preferred language.
+	// Every track is kept if empty.
+	AudioLanguages     []string
+	DropCommentary     bool     // Drop commentary audio tracks.
+	Languages          []string // Subtitle languages to extract, e.g. ["en", "es-419"]. A region only matches tracks whose title names it.
+	SubtitleFormats    []string // Formats of the extracted subtitles: vtt, srt and/or ass.
+	HearingImpairedTag string   // Naming tag of hearing impaired subtitles: sdh or hi. Defaults to sdh.
+	// Also write the external subtitle files of a download (.srt, .ass...) in the SubtitleFormats.
+	ConvertExternalSubtitles bool
+	KeepOriginalTracks       bool     // Keep the original audio tracks next to the compatible one.
+	SkipVideoCodecs          []string // Files with these video codecs are left untouched, e.g. ["hevc"]. Ignored with TranscodeVideo.
+	TranscodeVideo           bool     // Transcode video that isn't 8-bit H.264 (HEVC, 10-bit, HDR...) to 8-bit H.264 with libx264.
+	VideoCRF                 int      // libx264 CRF. Defaults to 20.
+	VideoPreset              string   // libx264 preset. Defaults to medium.
+	ToneMapping              string   // Tone mapping algorithm used to convert HDR to SDR: hable, mobius, reinhard... Defaults to hable.
+	OCR                      OCR      // Text recognition of bitmap (PGS/VobSub) subtitles.
+	// Devices files must direct-play on, e.g. ["chromecast", "browser"]. The audio, video and container are only
+	// converted when one of them can't play them. With devices, AudioCodec and AudioChannels are only the target of
+	// the created audio track, and they and Container are replaced by ones every device plays when needed.
+	Devices        []string
+	DeviceProfiles []DeviceProfile `toml:"-"` // Profiles of Devices, set by conversion.Profile.
+}
+
+// Convert bitmap subtitles to the subtitle formats of the profile with a local tesseract binary.
+type OCR struct {
+	Enabled       bool
+	Tesseract     string            // Path of the tesseract binary. Defaults to tesseract in PATH.
+	Models        map[string]string // Tesseract language models by subtitle language, e.g. {zh = "chi_tra"}. Defaults to the model of the language.
+	MinConfidence float64           // Mean word confidence, 0 to 100, a subtitle needs to be kept. Defaults to 60.
+}
+
+// What a client device direct-plays.
+type DeviceProfile struct {
+	Name             string   `toml:"-"`
+	Containers       []string // e.g. ["mp4", "mkv"].
+	VideoCodecs      []string // e.g. ["h264", "hevc"].
+	H264Profiles     []string // e.g. ["High", "Main"]. Any if empty.
+	MaxH264Level     int      // As ffprobe reports it, e.g. 41 for 4.1. Any if 0.
+	MaxWidth         int      // Any if 0.
+	TenBit           bool     // Plays 10-bit video.
+	HDR              bool     // Plays HDR video.
+	AudioCodecs      []string // e.g. ["aac", "ac3"].
+	MaxAudioChannels int
+	SubtitleFormats  []string // External subtitle formats, e.g. ["vtt"].
+}
+
+type conversion struct {
+	DefaultProfile string
+	Profiles       map[string]ConversionProfile
+	Devices        map[string]DeviceProfile // Device profiles added to, or replacing, the built-in ones.
+	Categories     map[string]string        // Profile name by category: radarr or tv-sonarr.
+	Qualities      map[string]string        // Profile name by release quality, e.g. Bluray-2160p. Takes precedence over the category.
+	// What is done with the extras (trailers, featurettes...) of a download by category: convert, upload or discard.
+	// Extras are uploaded into Featurettes/. Defaults to discard. Samples are always discarded.
+	Extras map[string]string
+	// How the movie or episodes in subdirectories of a download are uploaded by category: flatten (into the
+	// destination directory) or preserve (in their subdirectories). Defaults to flatten.
+	Layouts map[string]string
+}
+
 type ffmpeg struct {
-	Running bool
+	MaxProcesses int    // ffmpeg processes converting at once across every debridGo process. Defaults to 1 per 4 CPUs.
+	Priority     string // movies or episodes: the conversions that get a free ffmpeg slot first. Defaults to movies.
+}
+
+// Extraction of the archives of RAR/ZIP/7z releases.
+type archives struct {
+	Unrar     string   // Path of the unrar binary, for RAR archives. Defaults to unrar in PATH.
+	SevenZip  string   // Path of the 7z binary, for ZIP and 7z archives. Defaults to 7z in PATH.
+	Passwords []string // Passwords tried, in order, on encrypted archives.
 }
 
 type TomlConfig struct {
-	DebridGo   debridGo   `toml:"debridgo"`
-	Sonarr     sonarr     `toml:"sonarr"`
-	Radarr     radarr     `toml:"radarr"`
-	Bazarr     bazarr     `toml:"bazarr"`
-	Jellyseerr jellyseerr `toml:"jellyseerr"`
-	Rclone     rclone     `toml:"rclone"`
-	Emby       emby       `toml:"emby"`
-	Ffmpeg     ffmpeg     `toml:"ffmpeg"`
+	DebridGo    debridGo    `toml:"debridgo"`
+	Sonarr      sonarr      `toml:"sonarr"`
+	Radarr      radarr      `toml:"radarr"`
+	Bazarr      bazarr      `toml:"bazarr"`
+	Jellyseerr  jellyseerr  `toml:"jellyseerr"`
+	Rclone      rclone      `toml:"rclone"`
+	Emby        emby        `toml:"emby"`
+	Jellyfin    jellyfin    `toml:"jellyfin"`
+	Plex        plex        `toml:"plex"`
+	MediaServer mediaServer `toml:"mediaserver"`
+	Notify      notify      `toml:"notify"`
+	Conversion  conversion  `toml:"conversion"`
+	Ffmpeg      ffmpeg      `toml:"ffmpeg"`
+	Archives    archives    `toml:"archives"`
 }
 
 // //// data.json file in saveDir //// //
 type DataJSON struct {
-	TorrentHash string `json:"torrentHash"`
-	ID          int    `json:"id"`
-	Category    string `json:"category"`
-	RclonePath  string `json:"rclonePath"`
+	TorrentHash    string `json:"torrentHash"`
+	ID             int    `json:"id"`
+	Category       string `json:"category"`
+	RclonePath     string `json:"rclonePath"`
+	SeasonNumber   int    `json:"seasonNumber"`
+	EpisodeNumbers []int  `json:"episodeNumbers"`
+	TmdbId         int    `json:"tmdbId"`
+	TvdbId         int    `json:"tvdbId"`
+	Title          string `json:"title"`
+	Quality        string `json:"quality"`
 }
 
 // //////
@@ -71,6 +197,7 @@ type TorrentFile struct {
 type TorrentInfoResponseBody struct {
 	Id       string        `json:"id"`
 	Filename string        `json:"filename"`
+	Hash     string        `json:"hash"`
 	Files    []TorrentFile `json:"files"`
 	Status   string        `json:"status"`
 	Progress int           `json:"progress"`